@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// xmlEncodingPattern matches the encoding pseudo-attribute of an xml declaration
+var xmlEncodingPattern = regexp.MustCompile(`^(<\?xml[^>]*?\bencoding\s*=\s*["'])([^"']*)(["'])`)
+
+// windows1252 maps the bytes 0x80-0x9F to their code points. Every other byte is
+// identical to its ISO-8859-1 code point. Unassigned bytes map to the C1 control
+// with the same value, as browsers do
+var windows1252 = [32]rune{
+	0x20AC, 0x0081, 0x201A, 0x0192, 0x201E, 0x2026, 0x2020, 0x2021,
+	0x02C6, 0x2030, 0x0160, 0x2039, 0x0152, 0x008D, 0x017D, 0x008F,
+	0x0090, 0x2018, 0x2019, 0x201C, 0x201D, 0x2022, 0x2013, 0x2014,
+	0x02DC, 0x2122, 0x0161, 0x203A, 0x0153, 0x009D, 0x017E, 0x0178,
+}
+
+// decodeFeedCharset converts a feed document to UTF-8. The charset comes from
+// the xml declaration or, failing that, the Content-Type header. ISO-8859-1 is
+// decoded as its superset windows-1252, like browsers do. Invalid UTF-8 is
+// replaced rather than failing the whole feed, and reported as a warning
+func decodeFeedCharset(contentType string, data []byte) ([]byte, []string) {
+	warnings := []string{}
+
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	trimmed := bytes.TrimLeft(data, " \t\r\n")
+
+	charset := ""
+	declared := xmlEncodingPattern.FindSubmatch(trimmed)
+	if declared != nil {
+		charset = string(declared[2])
+	} else if _, params, err := mime.ParseMediaType(contentType); err == nil {
+		charset = params["charset"]
+	}
+
+	switch strings.ToLower(strings.TrimSpace(charset)) {
+	case "", "utf-8", "utf8", "us-ascii", "ascii":
+	case "iso-8859-1", "iso8859-1", "latin1", "l1", "windows-1252", "cp1252", "x-cp1252":
+		data = decodeWindows1252(data)
+	default:
+		warnings = append(warnings, fmt.Sprintf("unsupported charset %q, decoded as UTF-8", charset))
+	}
+
+	if !utf8.Valid(data) {
+		warnings = append(warnings, "document contains invalid UTF-8, invalid bytes were replaced")
+		data = bytes.ToValidUTF8(data, []byte("\uFFFD"))
+	}
+
+	// The document is UTF-8 now, so the declaration must say so or
+	// encoding/xml refuses to decode it
+	if declared != nil {
+		data = bytes.TrimLeft(data, " \t\r\n")
+		data = xmlEncodingPattern.ReplaceAll(data, []byte("${1}UTF-8${3}"))
+	}
+
+	return data, warnings
+}
+
+func decodeWindows1252(data []byte) []byte {
+	decoded := make([]byte, 0, len(data))
+	for _, b := range data {
+		switch {
+		case b < 0x80:
+			decoded = append(decoded, b)
+		case b < 0xA0:
+			decoded = utf8.AppendRune(decoded, windows1252[b-0x80])
+		default:
+			decoded = utf8.AppendRune(decoded, rune(b))
+		}
+	}
+	return decoded
+}
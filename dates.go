@@ -0,0 +1,148 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// pubDateLayouts are the date layouts seen in real-world feeds.
+// Weekday prefixes are stripped before parsing, so none of them include one
+var pubDateLayouts = []string{
+	"2 Jan 2006 15:04:05 -0700",
+	"2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04 -0700",
+	"2 Jan 2006 15:04 MST",
+	"2 Jan 06 15:04:05 -0700",
+	"2 Jan 06 15:04:05 MST",
+	"2 Jan 06 15:04 -0700",
+	"2 Jan 06 15:04 MST",
+	"2 January 2006 15:04:05 -0700",
+	"2 January 2006 15:04:05 MST",
+	"2 January 2006 15:04 -0700",
+	"2 January 2006 15:04 MST",
+	"2-Jan-06 15:04:05 MST",
+	"Jan 2 2006 15:04:05 -0700",
+	"Jan 2 2006 15:04:05 MST",
+	"Jan 2 15:04:05 MST 2006",
+	"Jan 2 15:04:05 2006",
+	"January 2, 2006 15:04:05 -0700",
+	"January 2, 2006",
+	"Jan 2, 2006 15:04:05 -0700",
+	"Jan 2, 2006 15:04:05 MST",
+	"Jan 2, 2006 15:04 -0700",
+	"Jan 2, 2006 15:04 MST",
+	"Jan 2, 2006 3:04 PM",
+	"Jan 2, 2006 15:04:05",
+	"Jan 2, 2006",
+	"2 Jan 2006",
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999-0700",
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05 MST",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// timezoneOffsets resolves the zone abbreviations publishers use in place of
+// numeric offsets. time.Parse only knows the local zone's abbreviations and
+// treats every other name as UTC
+var timezoneOffsets = map[string]int{
+	"UTC":  0,
+	"GMT":  0,
+	"EST":  -5 * 3600,
+	"EDT":  -4 * 3600,
+	"CST":  -6 * 3600,
+	"CDT":  -5 * 3600,
+	"MST":  -7 * 3600,
+	"MDT":  -6 * 3600,
+	"PST":  -8 * 3600,
+	"PDT":  -7 * 3600,
+	"AKST": -9 * 3600,
+	"AKDT": -8 * 3600,
+	"HST":  -10 * 3600,
+	"WET":  0,
+	"WEST": 1 * 3600,
+	"BST":  1 * 3600,
+	"CET":  1 * 3600,
+	"CEST": 2 * 3600,
+	"EET":  2 * 3600,
+	"EEST": 3 * 3600,
+	"MSK":  3 * 3600,
+	"IST":  5*3600 + 1800,
+	"SGT":  8 * 3600,
+	"HKT":  8 * 3600,
+	"JST":  9 * 3600,
+	"KST":  9 * 3600,
+	"AEST": 10 * 3600,
+	"AEDT": 11 * 3600,
+	"NZST": 12 * 3600,
+	"NZDT": 13 * 3600,
+}
+
+// parsePubDate tries each candidate date string in order, typically the
+// item's pubDate followed by its dc:date and updated values, and returns
+// the first one that parses
+func parsePubDate(candidates ...string) (time.Time, bool) {
+	for _, candidate := range candidates {
+		value := normalizeDate(candidate)
+		if value == "" {
+			continue
+		}
+		for _, layout := range pubDateLayouts {
+			t, err := time.Parse(layout, value)
+			if err == nil {
+				return resolveTimezone(t), true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// normalizeDate collapses whitespace, fixes the non-standard "Sept" month
+// abbreviation, drops a trailing comment such as "+0000 (UTC)", spells the
+// RFC 822 "UT" and military "Z" zones as an offset, since time.Parse only
+// accepts zone names of three or more letters, and removes the optional
+// weekday prefix ("Mon, " or "Monday, "), which publishers frequently get wrong anyway
+func normalizeDate(value string) string {
+	value = strings.Join(strings.Fields(value), " ")
+	value = strings.Replace(value, " Sept ", " Sep ", 1)
+	if open := strings.LastIndex(value, " ("); open > 0 && strings.HasSuffix(value, ")") {
+		value = value[:open]
+	}
+	for _, zone := range []string{" UT", " Z"} {
+		if strings.HasSuffix(value, zone) {
+			value = strings.TrimSuffix(value, zone) + " +0000"
+		}
+	}
+	if comma := strings.Index(value, ","); comma > 0 && isLetters(value[:comma]) {
+		value = strings.TrimSpace(value[comma+1:])
+	}
+	return value
+}
+
+func isLetters(s string) bool {
+	for _, r := range s {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// resolveTimezone applies the real offset for known zone abbreviations
+// that time.Parse could only record with a zero offset
+func resolveTimezone(t time.Time) time.Time {
+	name, offset := t.Zone()
+	if offset != 0 {
+		return t
+	}
+	known, ok := timezoneOffsets[strings.ToUpper(name)]
+	if !ok || known == 0 {
+		return t
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.FixedZone(name, known))
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParsePubDate(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		want   time.Time
+		wantOK bool
+	}{
+		{
+			name:   "rfc 1123 with offset",
+			value:  "Mon, 02 Jan 2006 15:04:05 -0700",
+			want:   time.Date(2006, 1, 2, 22, 4, 5, 0, time.UTC),
+			wantOK: true,
+		},
+		{
+			name:   "rfc 822 GMT",
+			value:  "Mon, 02 Jan 2006 15:04:05 GMT",
+			want:   time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC),
+			wantOK: true,
+		},
+		{
+			name:   "rfc 822 UT",
+			value:  "Mon, 02 Jan 2006 15:04:05 UT",
+			want:   time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC),
+			wantOK: true,
+		},
+		{
+			name:   "military Z zone",
+			value:  "02 Jan 2006 15:04:05 Z",
+			want:   time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC),
+			wantOK: true,
+		},
+		{
+			name:   "offset with zone comment",
+			value:  "Mon, 02 Jan 2006 15:04:05 +0000 (UTC)",
+			want:   time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC),
+			wantOK: true,
+		},
+		{
+			name:   "zone abbreviation",
+			value:  "Mon, 02 Jan 2006 15:04:05 EST",
+			want:   time.Date(2006, 1, 2, 20, 4, 5, 0, time.UTC),
+			wantOK: true,
+		},
+		{
+			name:   "full weekday and two digit year",
+			value:  "Monday, 02 Jan 06 15:04 +0200",
+			want:   time.Date(2006, 1, 2, 13, 4, 0, 0, time.UTC),
+			wantOK: true,
+		},
+		{
+			name:   "sept",
+			value:  "Sun, 24 Sept 2023 10:00:00 GMT",
+			want:   time.Date(2023, 9, 24, 10, 0, 0, 0, time.UTC),
+			wantOK: true,
+		},
+		{
+			name:   "rfc 3339",
+			value:  "2023-09-24T10:00:00+02:00",
+			want:   time.Date(2023, 9, 24, 8, 0, 0, 0, time.UTC),
+			wantOK: true,
+		},
+		{
+			name:   "iso 8601 offset without colon",
+			value:  "2023-09-24T10:00:00+0000",
+			want:   time.Date(2023, 9, 24, 10, 0, 0, 0, time.UTC),
+			wantOK: true,
+		},
+		{
+			name:   "iso 8601 fractional seconds without colon",
+			value:  "2023-09-24T10:00:00.123-0500",
+			want:   time.Date(2023, 9, 24, 15, 0, 0, 123000000, time.UTC),
+			wantOK: true,
+		},
+		{
+			name:   "abbreviated month with comma",
+			value:  "Jun 10, 2003",
+			want:   time.Date(2003, 6, 10, 0, 0, 0, 0, time.UTC),
+			wantOK: true,
+		},
+		{
+			name:   "abbreviated month with comma and time",
+			value:  "Jun 10, 2003 09:41:01 PDT",
+			want:   time.Date(2003, 6, 10, 16, 41, 1, 0, time.UTC),
+			wantOK: true,
+		},
+		{
+			name:   "abbreviated month with comma and offset",
+			value:  "Tue, Jun 10, 2003 09:41 +0200",
+			want:   time.Date(2003, 6, 10, 7, 41, 0, 0, time.UTC),
+			wantOK: true,
+		},
+		{
+			name:   "abbreviated month with comma and 12 hour clock",
+			value:  "Jun 10, 2003 9:41 PM",
+			want:   time.Date(2003, 6, 10, 21, 41, 0, 0, time.UTC),
+			wantOK: true,
+		},
+		{
+			name:   "date only",
+			value:  "2023-09-24",
+			want:   time.Date(2023, 9, 24, 0, 0, 0, 0, time.UTC),
+			wantOK: true,
+		},
+		{
+			name:   "empty",
+			value:  "  ",
+			wantOK: false,
+		},
+		{
+			name:   "garbage",
+			value:  "last Tuesday",
+			wantOK: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parsePubDate(tt.value)
+			if ok != tt.wantOK {
+				t.Fatalf("parsePubDate(%q) ok = %v, want %v", tt.value, ok, tt.wantOK)
+			}
+			if ok && !got.Equal(tt.want) {
+				t.Errorf("parsePubDate(%q) = %v, want %v", tt.value, got.UTC(), tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePubDateFallsBack(t *testing.T) {
+	got, ok := parsePubDate("not a date", "", "2006-01-03T10:00:00Z")
+	want := time.Date(2006, 1, 3, 10, 0, 0, 0, time.UTC)
+	if !ok || !got.Equal(want) {
+		t.Errorf("parsePubDate = %v, %v, want %v, true", got, ok, want)
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// feedLinkTypes are the <link type> values that advertise a feed
+var feedLinkTypes = map[string]string{
+	"application/rss+xml":   feedFormatRSS,
+	"application/atom+xml":  feedFormatAtom,
+	"application/rdf+xml":   feedFormatRDF,
+	"application/feed+json": feedFormatJSON,
+}
+
+// commonFeedPaths are probed, in order, when a page doesn't advertise its feeds
+var commonFeedPaths = []string{
+	"/feed",
+	"/rss.xml",
+	"/atom.xml",
+	"/feed.xml",
+	"/index.xml",
+}
+
+var (
+	// htmlHiddenPattern matches comments and script and style blocks, whose
+	// contents aren't markup even when they look like it. An unclosed one runs
+	// to the end of the document, like it does in a browser
+	htmlHiddenPattern    = regexp.MustCompile(`(?is)<!--.*?(?:-->|$)|<script\b.*?(?:</script\s*>|$)|<style\b.*?(?:</style\s*>|$)`)
+	htmlTagPattern       = regexp.MustCompile(`(?is)<(link|base)\b[^>]*>`)
+	htmlAttributePattern = regexp.MustCompile(`(?s)([a-zA-Z_:][-a-zA-Z0-9_:.]*)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'=<>` + "`" + `]+))`)
+)
+
+// Codes identifying why a submitted feed URL was rejected
+const (
+	feedErrorInvalidURL  = "invalid_url"
+	feedErrorUnreachable = "unreachable"
+	feedErrorHTTPStatus  = "http_error"
+	feedErrorNotAFeed    = "not_a_feed"
+	feedErrorNoFeeds     = "no_feeds_found"
+	feedErrorTooLarge    = "too_large"
+)
+
+// feedValidationError explains why a URL can't be subscribed to.
+// StatusCode is the publisher's response status, when it answered
+type feedValidationError struct {
+	Code       string
+	StatusCode int
+	Err        error
+}
+
+func (e *feedValidationError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *feedValidationError) Unwrap() error {
+	return e.Err
+}
+
+// feedCandidate is a feed discovered from the URL a user submitted
+type feedCandidate struct {
+	URL    string
+	Title  string
+	Format string
+}
+
+// feedDiscovery is the outcome of resolving a submitted URL. Either a single
+// feed was found and fetched, or the page advertises several Candidates
+type feedDiscovery struct {
+	URL        string
+	Feed       RSSFeed
+	Candidates []feedCandidate
+}
+
+// discoverFeeds resolves and validates the URL a user submitted, fetching it
+// the same way the scraper does. A URL that serves a feed resolves to itself.
+// For an HTML page the feeds advertised with <link rel="alternate"> are used,
+// falling back to the first of the common feed paths that serves a feed.
+// Errors are always a *feedValidationError
+func discoverFeeds(ctx context.Context, rawURL string) (feedDiscovery, error) {
+	pageURL, err := normalizeFeedURL(rawURL)
+	if err != nil {
+		return feedDiscovery{}, &feedValidationError{Code: feedErrorInvalidURL, Err: err}
+	}
+
+	doc, err := fetchDocument(ctx, pageURL, feedCacheHeaders{})
+	if err != nil {
+		return feedDiscovery{}, fetchValidationError(doc.StatusCode, err)
+	}
+
+	if !isHTMLDocument(doc.ContentType, doc.Data) {
+		rssFeed, err := parseFeed(doc.ContentType, doc.Data)
+		if err != nil {
+			return feedDiscovery{}, &feedValidationError{Code: feedErrorNotAFeed, StatusCode: doc.StatusCode, Err: err}
+		}
+		return feedDiscovery{URL: pageURL, Feed: rssFeed}, nil
+	}
+
+	candidates := htmlFeedLinks(doc.URL, doc.Data)
+	if len(candidates) > 1 {
+		return feedDiscovery{Candidates: candidates}, nil
+	}
+	if len(candidates) == 1 {
+		result, err := fetchFeed(ctx, candidates[0].URL, feedCacheHeaders{})
+		if err != nil {
+			return feedDiscovery{}, fetchValidationError(result.StatusCode, err)
+		}
+		return feedDiscovery{URL: candidates[0].URL, Feed: result.Feed}, nil
+	}
+
+	for _, path := range commonFeedPaths {
+		probeURL, err := resolveFeedLink(doc.URL, path)
+		if err != nil {
+			continue
+		}
+		probeURL, err = normalizeFeedURL(probeURL)
+		if err != nil {
+			continue
+		}
+		result, err := fetchFeed(ctx, probeURL, feedCacheHeaders{})
+		if err != nil {
+			continue
+		}
+		return feedDiscovery{URL: probeURL, Feed: result.Feed}, nil
+	}
+
+	return feedDiscovery{}, &feedValidationError{
+		Code:       feedErrorNoFeeds,
+		StatusCode: doc.StatusCode,
+		Err:        errors.New("the page does not link to any feed"),
+	}
+}
+
+// fetchValidationError classifies an error returned by fetchFeed or fetchDocument
+func fetchValidationError(statusCode int, err error) *feedValidationError {
+	switch {
+	case errors.Is(err, errPrivateAddress):
+		return &feedValidationError{Code: feedErrorInvalidURL, Err: errPrivateAddress}
+	case errors.Is(err, errFeedTooLarge):
+		return &feedValidationError{Code: feedErrorTooLarge, StatusCode: statusCode, Err: err}
+	case statusCode < 200:
+		return &feedValidationError{Code: feedErrorUnreachable, Err: err}
+	case statusCode > 299:
+		return &feedValidationError{Code: feedErrorHTTPStatus, StatusCode: statusCode, Err: err}
+	default:
+		return &feedValidationError{Code: feedErrorNotAFeed, StatusCode: statusCode, Err: err}
+	}
+}
+
+// isHTMLDocument reports whether a fetched document is a web page rather than a feed
+func isHTMLDocument(contentType string, data []byte) bool {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	if mediaType == "text/html" || mediaType == "application/xhtml+xml" {
+		return true
+	}
+	return strings.HasPrefix(http.DetectContentType(bytes.TrimSpace(data)), "text/html")
+}
+
+// htmlFeedLinks extracts the feeds advertised in an HTML page with
+// <link rel="alternate" type="...">, resolved against the page URL or
+// its <base href> and normalized. Duplicates are dropped
+func htmlFeedLinks(pageURL string, data []byte) []feedCandidate {
+	baseURL := pageURL
+	candidates := []feedCandidate{}
+	seen := map[string]bool{}
+
+	data = htmlHiddenPattern.ReplaceAll(data, nil)
+	for _, tag := range htmlTagPattern.FindAllSubmatch(data, -1) {
+		attrs := htmlAttributes(tag[0])
+
+		if strings.EqualFold(string(tag[1]), "base") {
+			if href, ok := attrs["href"]; ok {
+				if resolved, err := resolveFeedLink(pageURL, href); err == nil {
+					baseURL = resolved
+				}
+			}
+			continue
+		}
+
+		if !hasToken(attrs["rel"], "alternate") {
+			continue
+		}
+		format, ok := feedLinkTypes[strings.ToLower(strings.TrimSpace(attrs["type"]))]
+		if !ok || attrs["href"] == "" {
+			continue
+		}
+
+		feedURL, err := resolveFeedLink(baseURL, attrs["href"])
+		if err != nil {
+			continue
+		}
+		feedURL, err = normalizeFeedURL(feedURL)
+		if err != nil || seen[feedURL] {
+			continue
+		}
+		seen[feedURL] = true
+
+		candidates = append(candidates, feedCandidate{
+			URL:    feedURL,
+			Title:  strings.TrimSpace(attrs["title"]),
+			Format: format,
+		})
+	}
+	return candidates
+}
+
+// htmlAttributes parses the attributes of a single HTML tag into a map keyed
+// by lower-cased attribute name, with character references decoded
+func htmlAttributes(tag []byte) map[string]string {
+	attrs := map[string]string{}
+	for _, match := range htmlAttributePattern.FindAllSubmatch(tag, -1) {
+		name := strings.ToLower(string(match[1]))
+		if _, ok := attrs[name]; ok {
+			continue
+		}
+		value := string(match[2]) + string(match[3]) + string(match[4])
+		attrs[name] = html.UnescapeString(value)
+	}
+	return attrs
+}
+
+func hasToken(list, token string) bool {
+	for _, field := range strings.Fields(list) {
+		if strings.EqualFold(field, token) {
+			return true
+		}
+	}
+	return false
+}
+
+func resolveFeedLink(baseURL, href string) (string, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return "", err
+	}
+	ref, err := url.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return "", err
+	}
+	return base.ResolveReference(ref).String(), nil
+}
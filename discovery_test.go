@@ -0,0 +1,60 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestHTMLFeedLinks(t *testing.T) {
+	tests := []struct {
+		name string
+		page string
+		want []string
+	}{
+		{
+			name: "advertised feeds",
+			page: `<head>
+				<link rel="alternate" type="application/rss+xml" href="/rss.xml">
+				<link rel="alternate stylesheet" type="application/atom+xml" href="atom.xml">
+				<link rel="stylesheet" href="/style.css">
+			</head>`,
+			want: []string{"https://example.com/rss.xml", "https://example.com/blog/atom.xml"},
+		},
+		{
+			name: "base href",
+			page: `<base href="https://cdn.example.com/"><link rel=alternate type=application/feed+json href=feed.json>`,
+			want: []string{"https://cdn.example.com/feed.json"},
+		},
+		{
+			name: "commented out links",
+			page: `<link rel="alternate" type="application/rss+xml" href="/rss.xml">
+				<!-- <link rel=alternate type=application/rss+xml href=/commented> -->`,
+			want: []string{"https://example.com/rss.xml"},
+		},
+		{
+			name: "links in scripts and styles",
+			page: `<script>document.write('<link rel="alternate" type="application/rss+xml" href="/script">')</script>
+				<style>/* <link rel="alternate" type="application/rss+xml" href="/style"> */</style>
+				<link rel="alternate" type="application/rss+xml" href="/rss.xml">`,
+			want: []string{"https://example.com/rss.xml"},
+		},
+		{
+			name: "unclosed comment",
+			page: `<link rel="alternate" type="application/rss+xml" href="/rss.xml">
+				<!-- <link rel="alternate" type="application/rss+xml" href="/unclosed">`,
+			want: []string{"https://example.com/rss.xml"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			for _, candidate := range htmlFeedLinks("https://example.com/blog/", []byte(tt.page)) {
+				got = append(got, candidate.URL)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("htmlFeedLinks = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
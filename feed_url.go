@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// normalizeFeedURL canonicalises a feed URL so the same feed added with
+// different spellings maps to a single feeds row. The scheme and host are
+// lower-cased, default ports and fragments are dropped and an empty path
+// becomes "/". The path and query are kept as written, since servers may
+// treat them differently. Migration 020 applies the same rules in SQL, but
+// only to the URLs whose normalized form it can reproduce exactly
+func normalizeFeedURL(rawURL string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return "", err
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", errors.New("url must use http or https")
+	}
+	if u.Hostname() == "" {
+		return "", errors.New("url must include a host")
+	}
+
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if port != "" {
+		host += ":" + port
+	}
+	u.Host = host
+
+	u.Fragment = ""
+	u.RawFragment = ""
+	if u.Path == "" {
+		u.Path = "/"
+	}
+
+	return u.String(), nil
+}
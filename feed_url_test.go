@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestNormalizeFeedURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		rawURL  string
+		want    string
+		wantErr bool
+	}{
+		{name: "already normal", rawURL: "https://example.com/feed.xml", want: "https://example.com/feed.xml"},
+		{name: "scheme and host case", rawURL: "HTTPS://Example.COM/Feed.xml", want: "https://example.com/Feed.xml"},
+		{name: "surrounding whitespace", rawURL: "  https://example.com/feed  ", want: "https://example.com/feed"},
+		{name: "default http port", rawURL: "http://example.com:80/feed", want: "http://example.com/feed"},
+		{name: "default https port", rawURL: "https://example.com:443/feed", want: "https://example.com/feed"},
+		{name: "other port", rawURL: "https://example.com:8443/feed", want: "https://example.com:8443/feed"},
+		{name: "fragment", rawURL: "https://example.com/feed#latest", want: "https://example.com/feed"},
+		{name: "empty path", rawURL: "https://example.com", want: "https://example.com/"},
+		{name: "empty path with query", rawURL: "https://example.com?format=rss", want: "https://example.com/?format=rss"},
+		{name: "trailing slash kept", rawURL: "https://example.com/blog/feed/", want: "https://example.com/blog/feed/"},
+		{name: "encoded slash kept", rawURL: "https://example.com/tags/a%2Fb/feed", want: "https://example.com/tags/a%2Fb/feed"},
+		{name: "query kept", rawURL: "https://example.com/feed?b=2&a=1", want: "https://example.com/feed?b=2&a=1"},
+		{name: "ipv6 host", rawURL: "http://[::1]:80/feed", want: "http://[::1]/feed"},
+		{name: "unsupported scheme", rawURL: "ftp://example.com/feed", wantErr: true},
+		{name: "missing host", rawURL: "https:///feed", wantErr: true},
+		{name: "relative", rawURL: "/feed.xml", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeFeedURL(tt.rawURL)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("normalizeFeedURL(%q) error = %v, want error: %v", tt.rawURL, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("normalizeFeedURL(%q) = %q, want %q", tt.rawURL, got, tt.want)
+			}
+		})
+	}
+}
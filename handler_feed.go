@@ -1,17 +1,30 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"                            // For generating unique IDs
 	"github.com/ritikarora108/rssagg/internal/database" // Our database package
 )
 
-
-
+// HandlerCreateFeed subscribes the user to a feed URL. The URL is normalized and
+// an existing feed with the same URL is reused, so a feed is only scraped once
+// no matter how many users add it. Responds with the feed and the user's follow,
+// using 201 when the feed row was created and 200 when it already existed.
+// When the URL is a web page, the feed it advertises is used instead; a page
+// advertising several feeds gets a 300 with the candidates to choose from.
+// The feed is fetched and parsed before anything is saved, URLs that don't
+// serve a feed are rejected with a FeedValidationError, and the name
+// defaults to the channel title
 func (apiCfg *apiConfig) HandlerCreateFeed(w http.ResponseWriter, r *http.Request, user database.User) {
 	type parameters struct {
 		Name string `json:"name"`
@@ -24,21 +37,57 @@ func (apiCfg *apiConfig) HandlerCreateFeed(w http.ResponseWriter, r *http.Reques
 		respondWithError(w, 400, fmt.Sprintf("Error parsing JSON: %v", err))
 		return
 	}
-	feed, err := apiCfg.DB.CreateFeed(r.Context(), database.CreateFeedParams{
-		ID:        uuid.New(),
-		CreatedAt: time.Now().UTC(),
-		UpdatedAt: time.Now().UTC(),
-		Name:      params.Name,
-		Url:       params.Url,
-		UserID:    user.ID,
-	})
+
+	discovery, err := discoverFeeds(r.Context(), params.Url)
+	if err != nil {
+		respondWithFeedValidationError(w, err)
+		return
+	}
+	if len(discovery.Candidates) > 0 {
+		respondWithJSON(w, 300, feedCandidatesToFeedCandidates(discovery.Candidates))
+		return
+	}
+	feedURL := discovery.URL
+
+	name := strings.TrimSpace(params.Name)
+	if name == "" {
+		name = strings.TrimSpace(discovery.Feed.Channel.Title)
+	}
+	if name == "" {
+		name = feedURL
+	}
+
+	feed, feedFollow, created, err := apiCfg.followFeedByURL(r.Context(), user, name, feedURL, sql.NullString{})
 	if err != nil {
 		respondWithError(w, 400, fmt.Sprintf("Error creating feed: %v", err))
 		return
 	}
-	respondWithJSON(w, 201, databaseFeedToFeed(feed))
+
+	code := 200
+	if created {
+		code = 201
+	}
+	respondWithJSON(w, code, FeedWithFollow{
+		Feed:       databaseFeedToFeed(feed),
+		FeedFollow: databaseFeedFollowToFeedFollow(feedFollow),
+	})
 }
 
+// respondWithFeedValidationError reports why a submitted URL was rejected,
+// with a machine-readable code alongside the message
+func respondWithFeedValidationError(w http.ResponseWriter, err error) {
+	validationErr := &feedValidationError{}
+	if !errors.As(err, &validationErr) {
+		respondWithError(w, 400, fmt.Sprintf("Error validating feed: %v", err))
+		return
+	}
+
+	code := 422
+	if validationErr.Code == feedErrorInvalidURL {
+		code = 400
+	}
+	respondWithJSON(w, code, feedValidationErrorToFeedValidationError(validationErr))
+}
 
 func (apiCfg *apiConfig) HandlerGetFeeds(w http.ResponseWriter, r *http.Request, user database.User) {
 	feeds, err := apiCfg.DB.GetFeedsByUser(r.Context(), user.ID)
@@ -49,7 +98,6 @@ func (apiCfg *apiConfig) HandlerGetFeeds(w http.ResponseWriter, r *http.Request,
 	respondWithJSON(w, 200, databaseFeedsToFeeds(feeds))
 }
 
-
 func (apiCfg *apiConfig) HandlerGetAllFeeds(w http.ResponseWriter, r *http.Request) {
 	feeds, err := apiCfg.DB.GetFeeds(r.Context())
 	if err != nil {
@@ -58,3 +106,259 @@ func (apiCfg *apiConfig) HandlerGetAllFeeds(w http.ResponseWriter, r *http.Reque
 	}
 	respondWithJSON(w, 200, databaseFeedsToFeeds(feeds))
 }
+
+// followFeedByURL finds or creates the feed for an already normalized URL and makes
+// the user follow it, in a single transaction. The URL is locked for the duration of
+// the transaction so concurrent requests for the same URL can't create two feeds.
+// created reports whether a new feed row was inserted
+func (apiCfg *apiConfig) followFeedByURL(
+	ctx context.Context,
+	user database.User,
+	name string,
+	feedURL string,
+	category sql.NullString,
+) (feed database.Feed, feedFollow database.FeedFollow, created bool, err error) {
+	tx, err := apiCfg.DBConn.BeginTx(ctx, nil)
+	if err != nil {
+		return database.Feed{}, database.FeedFollow{}, false, err
+	}
+	defer tx.Rollback()
+
+	qtx := apiCfg.DB.WithTx(tx)
+
+	err = qtx.LockFeedURL(ctx, feedURL)
+	if err != nil {
+		return database.Feed{}, database.FeedFollow{}, false, err
+	}
+
+	feed, err = qtx.GetFeedByURL(ctx, feedURL)
+	if errors.Is(err, sql.ErrNoRows) {
+		created = true
+		feed, err = qtx.CreateFeed(ctx, database.CreateFeedParams{
+			ID:        uuid.New(),
+			CreatedAt: time.Now().UTC(),
+			UpdatedAt: time.Now().UTC(),
+			Name:      name,
+			Url:       feedURL,
+			UserID:    user.ID,
+		})
+	}
+	if err != nil {
+		return database.Feed{}, database.FeedFollow{}, false, err
+	}
+
+	feedFollow, err = qtx.UpsertFeedFollow(ctx, database.UpsertFeedFollowParams{
+		ID:        uuid.New(),
+		CreatedAt: time.Now().UTC(),
+		UpdatedAt: time.Now().UTC(),
+		UserID:    user.ID,
+		FeedID:    feed.ID,
+		Category:  category,
+	})
+	if err != nil {
+		return database.Feed{}, database.FeedFollow{}, false, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return database.Feed{}, database.FeedFollow{}, false, err
+	}
+	return feed, feedFollow, created, nil
+}
+
+// HandlerUpdateFeed renames a feed or changes its URL. Only the user who owns the
+// feed may update it. A new URL is discovered and validated like on creation, must
+// not belong to another feed, and can't be set while other users follow the feed.
+// It resets the cache validators, error state and health fields that came from
+// the old URL so the next fetch starts clean
+func (apiCfg *apiConfig) HandlerUpdateFeed(w http.ResponseWriter, r *http.Request, user database.User) {
+	feedID, err := uuid.Parse(chi.URLParam(r, "feedID"))
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("Error parsing feed ID: %v", err))
+		return
+	}
+
+	type parameters struct {
+		Name *string `json:"name"`
+		Url  *string `json:"url"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("Error parsing JSON: %v", err))
+		return
+	}
+	if params.Name != nil && strings.TrimSpace(*params.Name) == "" {
+		respondWithError(w, 400, "Feed name cannot be empty")
+		return
+	}
+
+	// The new URL is fetched before the feed is locked, so the lock isn't held for a whole fetch
+	var feedURL string
+	if params.Url != nil {
+		discovery, err := discoverFeeds(r.Context(), *params.Url)
+		if err != nil {
+			respondWithFeedValidationError(w, err)
+			return
+		}
+		if len(discovery.Candidates) > 0 {
+			respondWithJSON(w, 300, feedCandidatesToFeedCandidates(discovery.Candidates))
+			return
+		}
+		feedURL = discovery.URL
+	}
+
+	tx, err := apiCfg.DBConn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("Error updating feed: %v", err))
+		return
+	}
+	defer tx.Rollback()
+	qtx := apiCfg.DB.WithTx(tx)
+
+	feed, err := qtx.GetFeedForUpdate(r.Context(), feedID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, 404, "Feed not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("Error getting feed: %v", err))
+		return
+	}
+	if feed.UserID != user.ID {
+		respondWithError(w, 403, "User is not the owner of this feed")
+		return
+	}
+
+	updateParams := database.UpdateFeedParams{
+		ID:        feed.ID,
+		Name:      feed.Name,
+		Url:       feed.Url,
+		UpdatedAt: time.Now().UTC(),
+	}
+	if params.Name != nil {
+		updateParams.Name = strings.TrimSpace(*params.Name)
+	}
+	if params.Url != nil && feedURL != feed.Url {
+		// Followers subscribed to the old URL, so they aren't moved to a different one
+		_, err = qtx.GetNextFeedOwner(r.Context(), database.GetNextFeedOwnerParams{
+			FeedID: feed.ID,
+			UserID: user.ID,
+		})
+		if err == nil {
+			respondWithError(w, 409, "Feed is followed by other users, add the new URL as a new feed instead")
+			return
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, 400, fmt.Sprintf("Error updating feed: %v", err))
+			return
+		}
+
+		err = qtx.LockFeedURL(r.Context(), feedURL)
+		if err != nil {
+			respondWithError(w, 400, fmt.Sprintf("Error updating feed: %v", err))
+			return
+		}
+		_, err = qtx.GetFeedByURL(r.Context(), feedURL)
+		if err == nil {
+			respondWithError(w, 409, "Another feed already uses this URL")
+			return
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, 400, fmt.Sprintf("Error updating feed: %v", err))
+			return
+		}
+		updateParams.Url = feedURL
+	}
+
+	feed, err = qtx.UpdateFeed(r.Context(), updateParams)
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("Error updating feed: %v", err))
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("Error updating feed: %v", err))
+		return
+	}
+	respondWithJSON(w, 200, databaseFeedToFeed(feed))
+}
+
+// HandlerDeleteFeed deletes a feed owned by the user. While other users still follow
+// the feed, ownership passes to the longest-standing follower and only the owner's
+// follow is removed, unless cascade=true is given, in which case the feed is deleted
+// together with its posts and every follow
+func (apiCfg *apiConfig) HandlerDeleteFeed(w http.ResponseWriter, r *http.Request, user database.User) {
+	feedID, err := uuid.Parse(chi.URLParam(r, "feedID"))
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("Error parsing feed ID: %v", err))
+		return
+	}
+
+	cascade := false
+	if cascadeStr := r.URL.Query().Get("cascade"); cascadeStr != "" {
+		cascade, err = strconv.ParseBool(cascadeStr)
+		if err != nil {
+			respondWithError(w, 400, fmt.Sprintf("Error parsing cascade: %v", err))
+			return
+		}
+	}
+
+	tx, err := apiCfg.DBConn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("Error deleting feed: %v", err))
+		return
+	}
+	defer tx.Rollback()
+	qtx := apiCfg.DB.WithTx(tx)
+
+	feed, err := qtx.GetFeedForUpdate(r.Context(), feedID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, 404, "Feed not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("Error getting feed: %v", err))
+		return
+	}
+	if feed.UserID != user.ID {
+		respondWithError(w, 403, "User is not the owner of this feed")
+		return
+	}
+
+	message := "Feed deleted"
+	newOwnerID, err := qtx.GetNextFeedOwner(r.Context(), database.GetNextFeedOwnerParams{
+		FeedID: feed.ID,
+		UserID: user.ID,
+	})
+	switch {
+	case err == nil && !cascade:
+		err = qtx.TransferFeedOwnership(r.Context(), database.TransferFeedOwnershipParams{
+			ID:        feed.ID,
+			UserID:    newOwnerID,
+			UpdatedAt: time.Now().UTC(),
+		})
+		if err == nil {
+			err = qtx.DeleteFeedFollowByFeed(r.Context(), database.DeleteFeedFollowByFeedParams{
+				UserID: user.ID,
+				FeedID: feed.ID,
+			})
+		}
+		message = "Feed ownership transferred"
+	case err == nil || errors.Is(err, sql.ErrNoRows):
+		err = qtx.DeleteFeed(r.Context(), feed.ID)
+	}
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("Error deleting feed: %v", err))
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("Error deleting feed: %v", err))
+		return
+	}
+	respondWithJSON(w, 200, map[string]string{"message": message})
+}
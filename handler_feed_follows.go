@@ -53,10 +53,11 @@ func (apiCfg *apiConfig) HandlerCreateFeedFollow(w http.ResponseWriter, r *http.
 }
 
 // HandlerGetFeedFollowsByUser retrieves all feeds that a user is following
+// along with the number of unread posts in each of them
 // The user is authenticated via middleware and passed as a parameter
 func (apiCfg *apiConfig) HandlerGetFeedFollowsByUser(w http.ResponseWriter, r *http.Request, user database.User) {
 	// Get all feed follows for the authenticated user
-	feeds, err := apiCfg.DB.GetFeedFollowsByUser(r.Context(), user.ID)
+	feeds, err := apiCfg.DB.GetFeedFollowsWithUnreadCountByUser(r.Context(), user.ID)
 	if err != nil {
 		// If database operation fails, return a 400 error
 		respondWithError(w, 400, fmt.Sprintf("Error getting feeds: %v", err))
@@ -64,7 +65,7 @@ func (apiCfg *apiConfig) HandlerGetFeedFollowsByUser(w http.ResponseWriter, r *h
 	}
 
 	// Return the list of feed follows with 200 status code
-	respondWithJSON(w, 200, databaseFeedFollowsToFeedFollows(feeds))
+	respondWithJSON(w, 200, databaseFeedFollowsWithUnreadCountToFeedFollows(feeds))
 }
 
 // HandlerDeleteFeedFollow deletes a feed follow relationship
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/ritikarora108/rssagg/internal/database"
+)
+
+// HandlerPreviewFeed fetches and parses a feed URL like HandlerCreateFeed does,
+// without saving anything, so users can see what they'd get before subscribing.
+// The response has the first limit items normalized the way the scraper stores
+// them, plus warnings about dates and encoding problems in the document
+func (apiCfg *apiConfig) HandlerPreviewFeed(w http.ResponseWriter, r *http.Request, user database.User) {
+	limit, err := parseLimitParam(r)
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("Error parsing limit: %v", err))
+		return
+	}
+
+	feedURL := r.URL.Query().Get("url")
+	if feedURL == "" {
+		respondWithError(w, 400, "Missing url query parameter")
+		return
+	}
+
+	discovery, err := discoverFeeds(r.Context(), feedURL)
+	if err != nil {
+		respondWithFeedValidationError(w, err)
+		return
+	}
+	if len(discovery.Candidates) > 0 {
+		respondWithJSON(w, 300, feedCandidatesToFeedCandidates(discovery.Candidates))
+		return
+	}
+
+	respondWithJSON(w, 200, rssFeedToFeedPreview(discovery.URL, discovery.Feed, int(limit)))
+}
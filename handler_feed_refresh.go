@@ -0,0 +1,49 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/ritikarora108/rssagg/internal/database"
+)
+
+// HandlerRefreshFeed scrapes a followed feed immediately instead of waiting for
+// the scraper to reach it, and responds with what the run stored. Concurrent
+// requests for the same feed share one run, and refreshing a feed again too
+// soon, or while another instance is fetching it, is answered with 429 and a
+// Retry-After header
+func (apiCfg *apiConfig) HandlerRefreshFeed(w http.ResponseWriter, r *http.Request, user database.User) {
+	feedID, err := uuid.Parse(chi.URLParam(r, "feedID"))
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("Error parsing feed ID: %v", err))
+		return
+	}
+
+	feed, err := apiCfg.DB.GetFollowedFeed(r.Context(), database.GetFollowedFeedParams{
+		ID:     feedID,
+		UserID: user.ID,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, 404, "Feed not found in followed feeds")
+		return
+	}
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("Error getting feed: %v", err))
+		return
+	}
+
+	result, retryAfter := apiCfg.Refresher.refresh(feed)
+	if retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		respondWithError(w, 429, "Feed was refreshed recently or is being fetched, try again later")
+		return
+	}
+
+	respondWithJSON(w, 200, scrapeResultToFeedRefreshResult(result))
+}
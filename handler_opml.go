@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/ritikarora108/rssagg/internal/database"
+)
+
+// maxOPMLSize caps the size of an uploaded OPML document
+const maxOPMLSize = 5 << 20
+
+// Outcomes of importing a single OPML subscription
+const (
+	opmlStatusCreated  = "created"
+	opmlStatusExisting = "existing"
+	opmlStatusInvalid  = "invalid"
+	opmlStatusFailed   = "failed"
+)
+
+// HandlerImportOPML subscribes the user to every feed in an OPML 2.0 document
+// Feeds are deduplicated by normalized URL, folders become feed follow categories
+// and the response reports the outcome of each subscription outline
+func (apiCfg *apiConfig) HandlerImportOPML(w http.ResponseWriter, r *http.Request, user database.User) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxOPMLSize)
+
+	opml := OPML{}
+	err := xml.NewDecoder(r.Body).Decode(&opml)
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("Error parsing OPML: %v", err))
+		return
+	}
+
+	results := []OPMLImportResult{}
+	for _, subscription := range flattenOPMLOutlines(opml.Body.Outlines, "") {
+		results = append(results, apiCfg.importOPMLSubscription(r.Context(), user, subscription))
+	}
+
+	respondWithJSON(w, 200, results)
+}
+
+// HandlerExportOPML renders the user's followed feeds as an OPML 2.0 document
+// with categories exported as folders
+func (apiCfg *apiConfig) HandlerExportOPML(w http.ResponseWriter, r *http.Request, user database.User) {
+	feeds, err := apiCfg.DB.GetFollowedFeedsByUser(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("Error getting feeds: %v", err))
+		return
+	}
+
+	dat, err := xml.MarshalIndent(buildOPML(fmt.Sprintf("%v subscriptions", user.Name), feeds), "", "  ")
+	if err != nil {
+		respondWithError(w, 500, fmt.Sprintf("Error rendering OPML: %v", err))
+		return
+	}
+
+	w.Header().Add("Content-Type", "text/x-opml; charset=utf-8")
+	w.Header().Add("Content-Disposition", `attachment; filename="subscriptions.opml"`)
+	w.WriteHeader(200)
+	w.Write([]byte(xml.Header))
+	w.Write(dat)
+}
+
+func (apiCfg *apiConfig) importOPMLSubscription(ctx context.Context, user database.User, subscription opmlSubscription) OPMLImportResult {
+	result := OPMLImportResult{
+		Title:    subscription.Outline.name(),
+		XMLURL:   subscription.Outline.XMLURL,
+		Category: subscription.Category,
+	}
+	fail := func(status string, err error) OPMLImportResult {
+		message := err.Error()
+		result.Status = status
+		result.Error = &message
+		return result
+	}
+
+	if subscription.Outline.XMLURL == "" {
+		return fail(opmlStatusInvalid, errors.New("outline has no xmlUrl"))
+	}
+	feedURL, err := normalizeFeedURL(subscription.Outline.XMLURL)
+	if err != nil {
+		return fail(opmlStatusInvalid, err)
+	}
+
+	feed, _, created, err := apiCfg.followFeedByURL(ctx, user, result.Title, feedURL, sql.NullString{
+		String: subscription.Category,
+		Valid:  subscription.Category != "",
+	})
+	if err != nil {
+		return fail(opmlStatusFailed, err)
+	}
+
+	result.Status = opmlStatusExisting
+	if created {
+		result.Status = opmlStatusCreated
+	}
+	result.FeedID = &feed.ID
+	return result
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/ritikarora108/rssagg/internal/database"
+)
+
+// maxBulkReadPosts caps the number of post IDs accepted by a single bulk update
+const maxBulkReadPosts = 1000
+
+// HandlerMarkPostRead marks a single post as read for the user
+// Only posts from feeds the user follows can be marked
+func (apiCfg *apiConfig) HandlerMarkPostRead(w http.ResponseWriter, r *http.Request, user database.User) {
+	postID, err := uuid.Parse(chi.URLParam(r, "postID"))
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("Error parsing post ID: %v", err))
+		return
+	}
+
+	updated, err := apiCfg.DB.MarkPostsRead(r.Context(), database.MarkPostsReadParams{
+		ReadAt:  time.Now().UTC(),
+		UserID:  user.ID,
+		PostIds: []uuid.UUID{postID},
+	})
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("Error marking post as read: %v", err))
+		return
+	}
+
+	respondWithJSON(w, 200, map[string]int64{"updated": updated})
+}
+
+// HandlerMarkPostUnread removes the read state of a single post for the user
+func (apiCfg *apiConfig) HandlerMarkPostUnread(w http.ResponseWriter, r *http.Request, user database.User) {
+	postID, err := uuid.Parse(chi.URLParam(r, "postID"))
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("Error parsing post ID: %v", err))
+		return
+	}
+
+	updated, err := apiCfg.DB.MarkPostsUnread(r.Context(), database.MarkPostsUnreadParams{
+		UserID:  user.ID,
+		PostIds: []uuid.UUID{postID},
+	})
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("Error marking post as unread: %v", err))
+		return
+	}
+
+	respondWithJSON(w, 200, map[string]int64{"updated": updated})
+}
+
+// HandlerMarkPostsReadState marks many posts as read or unread at once
+// It expects a JSON body with post_ids and an optional read flag, which defaults to true
+func (apiCfg *apiConfig) HandlerMarkPostsReadState(w http.ResponseWriter, r *http.Request, user database.User) {
+	type parameters struct {
+		PostIDs []uuid.UUID `json:"post_ids"`
+		Read    *bool       `json:"read"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("Error parsing JSON: %v", err))
+		return
+	}
+
+	if len(params.PostIDs) == 0 || len(params.PostIDs) > maxBulkReadPosts {
+		respondWithError(w, 400, fmt.Sprintf("post_ids must contain between 1 and %v IDs", maxBulkReadPosts))
+		return
+	}
+
+	var updated int64
+	if params.Read == nil || *params.Read {
+		updated, err = apiCfg.DB.MarkPostsRead(r.Context(), database.MarkPostsReadParams{
+			ReadAt:  time.Now().UTC(),
+			UserID:  user.ID,
+			PostIds: params.PostIDs,
+		})
+	} else {
+		updated, err = apiCfg.DB.MarkPostsUnread(r.Context(), database.MarkPostsUnreadParams{
+			UserID:  user.ID,
+			PostIds: params.PostIDs,
+		})
+	}
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("Error updating read state: %v", err))
+		return
+	}
+
+	respondWithJSON(w, 200, map[string]int64{"updated": updated})
+}
+
+// HandlerMarkFeedRead marks every post of a followed feed published up to a
+// timestamp as read. The optional JSON body field until defaults to now
+func (apiCfg *apiConfig) HandlerMarkFeedRead(w http.ResponseWriter, r *http.Request, user database.User) {
+	feedID, err := uuid.Parse(chi.URLParam(r, "feedID"))
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("Error parsing feed ID: %v", err))
+		return
+	}
+
+	type parameters struct {
+		Until *time.Time `json:"until"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil && !errors.Is(err, io.EOF) {
+		respondWithError(w, 400, fmt.Sprintf("Error parsing JSON: %v", err))
+		return
+	}
+
+	now := time.Now().UTC()
+	until := now
+	if params.Until != nil {
+		until = params.Until.UTC()
+	}
+
+	updated, err := apiCfg.DB.MarkFeedPostsReadUntil(r.Context(), database.MarkFeedPostsReadUntilParams{
+		ReadAt: now,
+		UserID: user.ID,
+		FeedID: feedID,
+		Until:  until,
+	})
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("Error marking feed as read: %v", err))
+		return
+	}
+
+	respondWithJSON(w, 200, map[string]int64{"updated": updated})
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/ritikarora108/rssagg/internal/database"
+)
+
+// HandlerStarPost saves a post from a followed feed for later
+// Starring an already starred post returns the existing star
+func (apiCfg *apiConfig) HandlerStarPost(w http.ResponseWriter, r *http.Request, user database.User) {
+	postID, err := uuid.Parse(chi.URLParam(r, "postID"))
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("Error parsing post ID: %v", err))
+		return
+	}
+
+	star, err := apiCfg.DB.StarPost(r.Context(), database.StarPostParams{
+		ID:        uuid.New(),
+		CreatedAt: time.Now().UTC(),
+		PostID:    postID,
+		UserID:    user.ID,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, 404, "Post not found in followed feeds")
+		return
+	}
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("Error starring post: %v", err))
+		return
+	}
+
+	respondWithJSON(w, 200, databasePostStarToStarredPost(star))
+}
+
+// HandlerUnstarPost removes a post from the user's starred posts
+func (apiCfg *apiConfig) HandlerUnstarPost(w http.ResponseWriter, r *http.Request, user database.User) {
+	postID, err := uuid.Parse(chi.URLParam(r, "postID"))
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("Error parsing post ID: %v", err))
+		return
+	}
+
+	deleted, err := apiCfg.DB.UnstarPost(r.Context(), database.UnstarPostParams{
+		UserID: user.ID,
+		PostID: uuid.NullUUID{UUID: postID, Valid: true},
+	})
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("Error unstarring post: %v", err))
+		return
+	}
+	if deleted == 0 {
+		respondWithError(w, 404, "Post is not starred")
+		return
+	}
+
+	respondWithJSON(w, 200, map[string]string{"message": "Post unstarred"})
+}
+
+// HandlerDeleteStar removes a star by its own ID. Stars outlive their post, so
+// this is the only way to remove one whose post or feed has been deleted
+func (apiCfg *apiConfig) HandlerDeleteStar(w http.ResponseWriter, r *http.Request, user database.User) {
+	starID, err := uuid.Parse(chi.URLParam(r, "starID"))
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("Error parsing star ID: %v", err))
+		return
+	}
+
+	deleted, err := apiCfg.DB.DeleteStar(r.Context(), database.DeleteStarParams{
+		ID:     starID,
+		UserID: user.ID,
+	})
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("Error deleting star: %v", err))
+		return
+	}
+	if deleted == 0 {
+		respondWithError(w, 404, "Star not found")
+		return
+	}
+
+	respondWithJSON(w, 200, map[string]string{"message": "Post unstarred"})
+}
+
+// HandlerGetStarredPosts returns the user's starred posts, most recently starred first,
+// paginated with limit and cursor like GET /v1/posts
+func (apiCfg *apiConfig) HandlerGetStarredPosts(w http.ResponseWriter, r *http.Request, user database.User) {
+	limit, cursor, err := parsePageParams(r)
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+
+	params := database.GetStarredPostsByUserParams{
+		UserID: user.ID,
+		// Fetch one extra row to know whether there is a next page
+		Limit: limit + 1,
+	}
+	if cursor != nil {
+		params.CursorCreatedAt = sql.NullTime{Time: cursor.Time, Valid: true}
+		params.CursorID = uuid.NullUUID{UUID: cursor.ID, Valid: true}
+	}
+
+	stars, err := apiCfg.DB.GetStarredPostsByUser(r.Context(), params)
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("Couldn't get starred posts: %v", err))
+		return
+	}
+
+	var nextCursor *string
+	if len(stars) > int(limit) {
+		stars = stars[:limit]
+		last := stars[len(stars)-1]
+		encoded := encodeCursor(pageCursor{Time: last.CreatedAt, ID: last.ID})
+		nextCursor = &encoded
+	}
+
+	respondWithJSON(w, 200, StarredPostsPage{
+		Posts:      databasePostStarsToStarredPosts(stars),
+		NextCursor: nextCursor,
+	})
+}
@@ -1,22 +1,231 @@
 package main
 
 import (
+	"database/sql"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
 	"github.com/ritikarora108/rssagg/internal/database"
 )
 
+// postFilters are the optional query parameters accepted by GET /v1/posts
+type postFilters struct {
+	FeedIDs     []uuid.UUID
+	Since       sql.NullTime
+	Until       sql.NullTime
+	ByIngestion bool
+	UnreadOnly  bool
+}
 
-
+// HandlerGetPostsForUser returns posts from the feeds the user follows, newest first.
+// Pages are requested with the limit and cursor query parameters, and the
+// response carries the cursor for the next page until the history is exhausted.
+// Results can be narrowed with feed_id (repeatable), since, until and unread=true, and ordered
+// by publication (sort=published, the default) or ingestion time (sort=ingested).
+// The total number of matching posts is returned in the X-Total-Count header
 func (apiCfg *apiConfig) HandlerGetPostsForUser(w http.ResponseWriter, r *http.Request, user database.User) {
-	posts, err := apiCfg.DB.GetPostsByUser(r.Context(), database.GetPostsByUserParams{
-		UserID: user.ID,
-		Limit:  10,
+	limit, cursor, err := parsePageParams(r)
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+
+	filters, err := parsePostFilters(r)
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+
+	total, err := apiCfg.DB.CountPostsByUser(r.Context(), database.CountPostsByUserParams{
+		UserID:      user.ID,
+		FeedIds:     filters.FeedIDs,
+		Since:       filters.Since,
+		ByIngestion: filters.ByIngestion,
+		Until:       filters.Until,
+		UnreadOnly:  filters.UnreadOnly,
 	})
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("Couldn't count posts: %v", err))
+		return
+	}
+
+	var cursorTime sql.NullTime
+	var cursorID uuid.NullUUID
+	if cursor != nil {
+		cursorTime = sql.NullTime{Time: cursor.Time, Valid: true}
+		cursorID = uuid.NullUUID{UUID: cursor.ID, Valid: true}
+	}
+
+	// Fetch one extra row to know whether there is a next page
+	var posts []Post
+	if filters.ByIngestion {
+		var rows []database.GetPostsByUserOrderedByIngestionRow
+		rows, err = apiCfg.DB.GetPostsByUserOrderedByIngestion(r.Context(), database.GetPostsByUserOrderedByIngestionParams{
+			UserID:          user.ID,
+			FeedIds:         filters.FeedIDs,
+			Since:           filters.Since,
+			Until:           filters.Until,
+			UnreadOnly:      filters.UnreadOnly,
+			CursorCreatedAt: cursorTime,
+			CursorID:        cursorID,
+			Limit:           limit + 1,
+		})
+		posts = databaseIngestionRowsToPosts(rows)
+	} else {
+		var rows []database.GetPostsByUserRow
+		rows, err = apiCfg.DB.GetPostsByUser(r.Context(), database.GetPostsByUserParams{
+			UserID:            user.ID,
+			FeedIds:           filters.FeedIDs,
+			Since:             filters.Since,
+			Until:             filters.Until,
+			UnreadOnly:        filters.UnreadOnly,
+			CursorPublishedAt: cursorTime,
+			CursorID:          cursorID,
+			Limit:             limit + 1,
+		})
+		posts = databasePostRowsToPosts(rows)
+	}
 	if err != nil {
 		respondWithError(w, 400, fmt.Sprintf("Couldn't get posts: %v", err))
 		return
 	}
-	respondWithJSON(w, 200, databasePostsToPosts(posts))
+
+	var nextCursor *string
+	if len(posts) > int(limit) {
+		posts = posts[:limit]
+		last := posts[len(posts)-1]
+		next := pageCursor{Time: last.PublishedAt, ID: last.ID}
+		if filters.ByIngestion {
+			next.Time = last.CreatedAt
+		}
+		encoded := encodeCursor(next)
+		nextCursor = &encoded
+	}
+
+	w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+	respondWithJSON(w, 200, PostsPage{
+		Posts:      posts,
+		NextCursor: nextCursor,
+	})
+}
+
+// HandlerSearchPosts runs a full-text search over posts from the feeds the user follows.
+// The q parameter supports "quoted phrases", prefix* terms and -excluded terms.
+// Results are ranked with title matches above description matches and paged with limit and offset
+func (apiCfg *apiConfig) HandlerSearchPosts(w http.ResponseWriter, r *http.Request, user database.User) {
+	query, err := buildSearchQuery(r.URL.Query().Get("q"))
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+
+	limit, err := parseLimitParam(r)
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+
+	offset := 0
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		offset, err = strconv.Atoi(offsetStr)
+		if err != nil || offset < 0 {
+			respondWithError(w, 400, "offset must be a non-negative integer")
+			return
+		}
+	}
+
+	results, err := apiCfg.DB.SearchPostsByUser(r.Context(), database.SearchPostsByUserParams{
+		Query:  query,
+		UserID: user.ID,
+		Limit:  limit,
+		Offset: int32(offset),
+	})
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("Couldn't search posts: %v", err))
+		return
+	}
+
+	respondWithJSON(w, 200, databaseSearchResultsToSearchResults(results))
+}
+
+// HandlerGetPostRevisions returns the previous versions of a post from a followed feed,
+// most recent first. Posts that never changed have no revisions
+func (apiCfg *apiConfig) HandlerGetPostRevisions(w http.ResponseWriter, r *http.Request, user database.User) {
+	postID, err := uuid.Parse(chi.URLParam(r, "postID"))
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("Error parsing post ID: %v", err))
+		return
+	}
+
+	revisions, err := apiCfg.DB.GetPostRevisionsByUser(r.Context(), database.GetPostRevisionsByUserParams{
+		PostID: postID,
+		UserID: user.ID,
+	})
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("Couldn't get post revisions: %v", err))
+		return
+	}
+
+	respondWithJSON(w, 200, databasePostRevisionsToPostRevisions(revisions))
+}
+
+func parsePostFilters(r *http.Request) (postFilters, error) {
+	query := r.URL.Query()
+	filters := postFilters{}
+
+	// feed_id may be repeated or given as a comma separated list
+	for _, value := range query["feed_id"] {
+		for _, idStr := range strings.Split(value, ",") {
+			id, err := uuid.Parse(strings.TrimSpace(idStr))
+			if err != nil {
+				return postFilters{}, fmt.Errorf("Error parsing feed_id: %v", err)
+			}
+			filters.FeedIDs = append(filters.FeedIDs, id)
+		}
+	}
+
+	var err error
+	filters.Since, err = parseTimeParam(query.Get("since"))
+	if err != nil {
+		return postFilters{}, fmt.Errorf("Error parsing since: %v", err)
+	}
+	filters.Until, err = parseTimeParam(query.Get("until"))
+	if err != nil {
+		return postFilters{}, fmt.Errorf("Error parsing until: %v", err)
+	}
+
+	if unread := query.Get("unread"); unread != "" {
+		filters.UnreadOnly, err = strconv.ParseBool(unread)
+		if err != nil {
+			return postFilters{}, fmt.Errorf("Error parsing unread: %v", err)
+		}
+	}
+
+	switch query.Get("sort") {
+	case "", "published":
+	case "ingested":
+		filters.ByIngestion = true
+	default:
+		return postFilters{}, fmt.Errorf("sort must be one of published, ingested")
+	}
+
+	return filters, nil
+}
+
+// parseTimeParam parses an optional RFC 3339 query parameter into UTC,
+// matching how timestamps are stored
+func parseTimeParam(value string) (sql.NullTime, error) {
+	if value == "" {
+		return sql.NullTime{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return sql.NullTime{}, err
+	}
+	return sql.NullTime{Time: t.UTC(), Valid: true}, nil
 }
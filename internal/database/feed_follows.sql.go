@@ -7,6 +7,7 @@ package database
 
 import (
 	"context"
+	"database/sql"
 	"time"
 
 	"github.com/google/uuid"
@@ -15,7 +16,7 @@ import (
 const createFeedFollow = `-- name: CreateFeedFollow :one
 INSERT INTO feed_follows (id, created_at, updated_at, user_id, feed_id)
 VALUES ($1, $2, $3, $4, $5)
-RETURNING id, created_at, updated_at, user_id, feed_id
+RETURNING id, created_at, updated_at, user_id, feed_id, category
 `
 
 type CreateFeedFollowParams struct {
@@ -41,6 +42,7 @@ func (q *Queries) CreateFeedFollow(ctx context.Context, arg CreateFeedFollowPara
 		&i.UpdatedAt,
 		&i.UserID,
 		&i.FeedID,
+		&i.Category,
 	)
 	return i, err
 }
@@ -59,8 +61,22 @@ func (q *Queries) DeleteFeedFollow(ctx context.Context, arg DeleteFeedFollowPara
 	return err
 }
 
+const deleteFeedFollowByFeed = `-- name: DeleteFeedFollowByFeed :exec
+DELETE FROM feed_follows WHERE user_id = $1 AND feed_id = $2
+`
+
+type DeleteFeedFollowByFeedParams struct {
+	UserID uuid.UUID
+	FeedID uuid.UUID
+}
+
+func (q *Queries) DeleteFeedFollowByFeed(ctx context.Context, arg DeleteFeedFollowByFeedParams) error {
+	_, err := q.db.ExecContext(ctx, deleteFeedFollowByFeed, arg.UserID, arg.FeedID)
+	return err
+}
+
 const getFeedFollow = `-- name: GetFeedFollow :one
-SELECT id, created_at, updated_at, user_id, feed_id FROM feed_follows WHERE id = $1
+SELECT id, created_at, updated_at, user_id, feed_id, category FROM feed_follows WHERE id = $1
 `
 
 func (q *Queries) GetFeedFollow(ctx context.Context, id uuid.UUID) (FeedFollow, error) {
@@ -72,12 +88,13 @@ func (q *Queries) GetFeedFollow(ctx context.Context, id uuid.UUID) (FeedFollow,
 		&i.UpdatedAt,
 		&i.UserID,
 		&i.FeedID,
+		&i.Category,
 	)
 	return i, err
 }
 
 const getFeedFollowsByUser = `-- name: GetFeedFollowsByUser :many
-SELECT id, created_at, updated_at, user_id, feed_id FROM feed_follows WHERE user_id = $1
+SELECT id, created_at, updated_at, user_id, feed_id, category FROM feed_follows WHERE user_id = $1
 `
 
 func (q *Queries) GetFeedFollowsByUser(ctx context.Context, userID uuid.UUID) ([]FeedFollow, error) {
@@ -95,6 +112,7 @@ func (q *Queries) GetFeedFollowsByUser(ctx context.Context, userID uuid.UUID) ([
 			&i.UpdatedAt,
 			&i.UserID,
 			&i.FeedID,
+			&i.Category,
 		); err != nil {
 			return nil, err
 		}
@@ -108,3 +126,196 @@ func (q *Queries) GetFeedFollowsByUser(ctx context.Context, userID uuid.UUID) ([
 	}
 	return items, nil
 }
+
+const getFeedFollowsWithUnreadCountByUser = `-- name: GetFeedFollowsWithUnreadCountByUser :many
+SELECT feed_follows.id, feed_follows.created_at, feed_follows.updated_at, feed_follows.user_id, feed_follows.feed_id, feed_follows.category, (
+    SELECT COUNT(*) FROM posts
+    WHERE posts.feed_id = feed_follows.feed_id
+    AND NOT EXISTS (
+        SELECT 1 FROM post_reads
+        WHERE post_reads.user_id = feed_follows.user_id
+        AND post_reads.post_id = posts.id
+    )
+) AS unread_count
+FROM feed_follows
+WHERE feed_follows.user_id = $1
+`
+
+type GetFeedFollowsWithUnreadCountByUserRow struct {
+	ID          uuid.UUID
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	UserID      uuid.UUID
+	FeedID      uuid.UUID
+	Category    sql.NullString
+	UnreadCount int64
+}
+
+func (q *Queries) GetFeedFollowsWithUnreadCountByUser(ctx context.Context, userID uuid.UUID) ([]GetFeedFollowsWithUnreadCountByUserRow, error) {
+	rows, err := q.db.QueryContext(ctx, getFeedFollowsWithUnreadCountByUser, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetFeedFollowsWithUnreadCountByUserRow
+	for rows.Next() {
+		var i GetFeedFollowsWithUnreadCountByUserRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.UserID,
+			&i.FeedID,
+			&i.Category,
+			&i.UnreadCount,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getFollowedFeed = `-- name: GetFollowedFeed :one
+SELECT feeds.id, feeds.created_at, feeds.updated_at, feeds.name, feeds.url, feeds.user_id, feeds.last_fetched_at, feeds.etag, feeds.last_modified, feeds.last_error, feeds.consecutive_failures, feeds.next_fetch_at, feeds.last_success_at, feeds.last_http_status, feeds.item_count, feeds.site_url, feeds.leased_until, feeds.unparseable_dates FROM feeds
+JOIN feed_follows ON feed_follows.feed_id = feeds.id
+WHERE feeds.id = $1 AND feed_follows.user_id = $2
+`
+
+type GetFollowedFeedParams struct {
+	ID     uuid.UUID
+	UserID uuid.UUID
+}
+
+func (q *Queries) GetFollowedFeed(ctx context.Context, arg GetFollowedFeedParams) (Feed, error) {
+	row := q.db.QueryRowContext(ctx, getFollowedFeed, arg.ID, arg.UserID)
+	var i Feed
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Name,
+		&i.Url,
+		&i.UserID,
+		&i.LastFetchedAt,
+		&i.Etag,
+		&i.LastModified,
+		&i.LastError,
+		&i.ConsecutiveFailures,
+		&i.NextFetchAt,
+		&i.LastSuccessAt,
+		&i.LastHttpStatus,
+		&i.ItemCount,
+		&i.SiteUrl,
+		&i.LeasedUntil,
+		&i.UnparseableDates,
+	)
+	return i, err
+}
+
+const getFollowedFeedsByUser = `-- name: GetFollowedFeedsByUser :many
+SELECT feeds.name, feeds.url, feeds.site_url, feed_follows.category
+FROM feed_follows
+JOIN feeds ON feeds.id = feed_follows.feed_id
+WHERE feed_follows.user_id = $1
+ORDER BY feed_follows.category ASC NULLS FIRST, feeds.name ASC
+`
+
+type GetFollowedFeedsByUserRow struct {
+	Name     string
+	Url      string
+	SiteUrl  sql.NullString
+	Category sql.NullString
+}
+
+func (q *Queries) GetFollowedFeedsByUser(ctx context.Context, userID uuid.UUID) ([]GetFollowedFeedsByUserRow, error) {
+	rows, err := q.db.QueryContext(ctx, getFollowedFeedsByUser, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetFollowedFeedsByUserRow
+	for rows.Next() {
+		var i GetFollowedFeedsByUserRow
+		if err := rows.Scan(
+			&i.Name,
+			&i.Url,
+			&i.SiteUrl,
+			&i.Category,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getNextFeedOwner = `-- name: GetNextFeedOwner :one
+SELECT user_id FROM feed_follows
+WHERE feed_id = $1 AND user_id <> $2
+ORDER BY created_at ASC
+LIMIT 1
+`
+
+type GetNextFeedOwnerParams struct {
+	FeedID uuid.UUID
+	UserID uuid.UUID
+}
+
+func (q *Queries) GetNextFeedOwner(ctx context.Context, arg GetNextFeedOwnerParams) (uuid.UUID, error) {
+	row := q.db.QueryRowContext(ctx, getNextFeedOwner, arg.FeedID, arg.UserID)
+	var user_id uuid.UUID
+	err := row.Scan(&user_id)
+	return user_id, err
+}
+
+const upsertFeedFollow = `-- name: UpsertFeedFollow :one
+INSERT INTO feed_follows (id, created_at, updated_at, user_id, feed_id, category)
+VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT (user_id, feed_id) DO UPDATE
+SET category = COALESCE(EXCLUDED.category, feed_follows.category),
+updated_at = EXCLUDED.updated_at
+RETURNING id, created_at, updated_at, user_id, feed_id, category
+`
+
+type UpsertFeedFollowParams struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	UserID    uuid.UUID
+	FeedID    uuid.UUID
+	Category  sql.NullString
+}
+
+func (q *Queries) UpsertFeedFollow(ctx context.Context, arg UpsertFeedFollowParams) (FeedFollow, error) {
+	row := q.db.QueryRowContext(ctx, upsertFeedFollow,
+		arg.ID,
+		arg.CreatedAt,
+		arg.UpdatedAt,
+		arg.UserID,
+		arg.FeedID,
+		arg.Category,
+	)
+	var i FeedFollow
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.UserID,
+		&i.FeedID,
+		&i.Category,
+	)
+	return i, err
+}
@@ -7,35 +7,31 @@ package database
 
 import (
 	"context"
+	"database/sql"
 	"time"
 
 	"github.com/google/uuid"
 )
 
-const createFeed = `-- name: CreateFeed :one
-INSERT INTO feeds (id, created_at, updated_at, name, url, user_id)
-VALUES ($1, $2, $3, $4, $5, $6)
-RETURNING id, created_at, updated_at, name, url, user_id, last_fetched_at
+const claimFeed = `-- name: ClaimFeed :one
+UPDATE feeds
+SET leased_until = (NOW() AT TIME ZONE 'UTC') + make_interval(secs => $1::float8)
+WHERE id = $2
+AND (last_fetched_at IS NULL OR last_fetched_at <= (NOW() AT TIME ZONE 'UTC') - make_interval(secs => $3::float8))
+AND (leased_until IS NULL OR leased_until <= NOW() AT TIME ZONE 'UTC')
+RETURNING id, created_at, updated_at, name, url, user_id, last_fetched_at, etag, last_modified, last_error, consecutive_failures, next_fetch_at, last_success_at, last_http_status, item_count, site_url, leased_until, unparseable_dates
 `
 
-type CreateFeedParams struct {
-	ID        uuid.UUID
-	CreatedAt time.Time
-	UpdatedAt time.Time
-	Name      string
-	Url       string
-	UserID    uuid.UUID
+type ClaimFeedParams struct {
+	LeaseSeconds    float64
+	ID              uuid.UUID
+	IntervalSeconds float64
 }
 
-func (q *Queries) CreateFeed(ctx context.Context, arg CreateFeedParams) (Feed, error) {
-	row := q.db.QueryRowContext(ctx, createFeed,
-		arg.ID,
-		arg.CreatedAt,
-		arg.UpdatedAt,
-		arg.Name,
-		arg.Url,
-		arg.UserID,
-	)
+// Leases a single feed for an on-demand fetch. Nothing is returned when another
+// instance holds its lease or it was fetched within interval_seconds
+func (q *Queries) ClaimFeed(ctx context.Context, arg ClaimFeedParams) (Feed, error) {
+	row := q.db.QueryRowContext(ctx, claimFeed, arg.LeaseSeconds, arg.ID, arg.IntervalSeconds)
 	var i Feed
 	err := row.Scan(
 		&i.ID,
@@ -45,16 +41,46 @@ func (q *Queries) CreateFeed(ctx context.Context, arg CreateFeedParams) (Feed, e
 		&i.Url,
 		&i.UserID,
 		&i.LastFetchedAt,
+		&i.Etag,
+		&i.LastModified,
+		&i.LastError,
+		&i.ConsecutiveFailures,
+		&i.NextFetchAt,
+		&i.LastSuccessAt,
+		&i.LastHttpStatus,
+		&i.ItemCount,
+		&i.SiteUrl,
+		&i.LeasedUntil,
+		&i.UnparseableDates,
 	)
 	return i, err
 }
 
-const getFeeds = `-- name: GetFeeds :many
-SELECT id, created_at, updated_at, name, url, user_id, last_fetched_at FROM feeds
+const claimNextFeedsToFetch = `-- name: ClaimNextFeedsToFetch :many
+UPDATE feeds
+SET leased_until = (NOW() AT TIME ZONE 'UTC') + make_interval(secs => $1::float8)
+WHERE id IN (
+    SELECT id FROM feeds
+    WHERE (next_fetch_at IS NULL OR next_fetch_at <= NOW() AT TIME ZONE 'UTC')
+    AND (last_fetched_at IS NULL OR last_fetched_at <= (NOW() AT TIME ZONE 'UTC') - make_interval(secs => $2::float8))
+    AND (leased_until IS NULL OR leased_until <= NOW() AT TIME ZONE 'UTC')
+    ORDER BY last_fetched_at ASC NULLS FIRST
+    LIMIT $3
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING id, created_at, updated_at, name, url, user_id, last_fetched_at, etag, last_modified, last_error, consecutive_failures, next_fetch_at, last_success_at, last_http_status, item_count, site_url, leased_until, unparseable_dates
 `
 
-func (q *Queries) GetFeeds(ctx context.Context) ([]Feed, error) {
-	rows, err := q.db.QueryContext(ctx, getFeeds)
+type ClaimNextFeedsToFetchParams struct {
+	LeaseSeconds    float64
+	IntervalSeconds float64
+	Limit           int32
+}
+
+// Fetch times are stored in UTC from the database clock, so every instance
+// agrees on when a feed is due whatever its own clock or the session time zone
+func (q *Queries) ClaimNextFeedsToFetch(ctx context.Context, arg ClaimNextFeedsToFetchParams) ([]Feed, error) {
+	rows, err := q.db.QueryContext(ctx, claimNextFeedsToFetch, arg.LeaseSeconds, arg.IntervalSeconds, arg.Limit)
 	if err != nil {
 		return nil, err
 	}
@@ -70,6 +96,17 @@ func (q *Queries) GetFeeds(ctx context.Context) ([]Feed, error) {
 			&i.Url,
 			&i.UserID,
 			&i.LastFetchedAt,
+			&i.Etag,
+			&i.LastModified,
+			&i.LastError,
+			&i.ConsecutiveFailures,
+			&i.NextFetchAt,
+			&i.LastSuccessAt,
+			&i.LastHttpStatus,
+			&i.ItemCount,
+			&i.SiteUrl,
+			&i.LeasedUntil,
+			&i.UnparseableDates,
 		); err != nil {
 			return nil, err
 		}
@@ -84,13 +121,132 @@ func (q *Queries) GetFeeds(ctx context.Context) ([]Feed, error) {
 	return items, nil
 }
 
-const getFeedsByUser = `-- name: GetFeedsByUser :many
-SELECT id, created_at, updated_at, name, url, user_id, last_fetched_at FROM feeds
-WHERE user_id = $1
+const createFeed = `-- name: CreateFeed :one
+INSERT INTO feeds (id, created_at, updated_at, name, url, user_id)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id, created_at, updated_at, name, url, user_id, last_fetched_at, etag, last_modified, last_error, consecutive_failures, next_fetch_at, last_success_at, last_http_status, item_count, site_url, leased_until, unparseable_dates
 `
 
-func (q *Queries) GetFeedsByUser(ctx context.Context, userID uuid.UUID) ([]Feed, error) {
-	rows, err := q.db.QueryContext(ctx, getFeedsByUser, userID)
+type CreateFeedParams struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Name      string
+	Url       string
+	UserID    uuid.UUID
+}
+
+func (q *Queries) CreateFeed(ctx context.Context, arg CreateFeedParams) (Feed, error) {
+	row := q.db.QueryRowContext(ctx, createFeed,
+		arg.ID,
+		arg.CreatedAt,
+		arg.UpdatedAt,
+		arg.Name,
+		arg.Url,
+		arg.UserID,
+	)
+	var i Feed
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Name,
+		&i.Url,
+		&i.UserID,
+		&i.LastFetchedAt,
+		&i.Etag,
+		&i.LastModified,
+		&i.LastError,
+		&i.ConsecutiveFailures,
+		&i.NextFetchAt,
+		&i.LastSuccessAt,
+		&i.LastHttpStatus,
+		&i.ItemCount,
+		&i.SiteUrl,
+		&i.LeasedUntil,
+		&i.UnparseableDates,
+	)
+	return i, err
+}
+
+const deleteFeed = `-- name: DeleteFeed :exec
+DELETE FROM feeds WHERE id = $1
+`
+
+func (q *Queries) DeleteFeed(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, deleteFeed, id)
+	return err
+}
+
+const getFeedByURL = `-- name: GetFeedByURL :one
+SELECT id, created_at, updated_at, name, url, user_id, last_fetched_at, etag, last_modified, last_error, consecutive_failures, next_fetch_at, last_success_at, last_http_status, item_count, site_url, leased_until, unparseable_dates FROM feeds
+WHERE url = $1
+`
+
+func (q *Queries) GetFeedByURL(ctx context.Context, url string) (Feed, error) {
+	row := q.db.QueryRowContext(ctx, getFeedByURL, url)
+	var i Feed
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Name,
+		&i.Url,
+		&i.UserID,
+		&i.LastFetchedAt,
+		&i.Etag,
+		&i.LastModified,
+		&i.LastError,
+		&i.ConsecutiveFailures,
+		&i.NextFetchAt,
+		&i.LastSuccessAt,
+		&i.LastHttpStatus,
+		&i.ItemCount,
+		&i.SiteUrl,
+		&i.LeasedUntil,
+		&i.UnparseableDates,
+	)
+	return i, err
+}
+
+const getFeedForUpdate = `-- name: GetFeedForUpdate :one
+SELECT id, created_at, updated_at, name, url, user_id, last_fetched_at, etag, last_modified, last_error, consecutive_failures, next_fetch_at, last_success_at, last_http_status, item_count, site_url, leased_until, unparseable_dates FROM feeds
+WHERE id = $1
+FOR UPDATE
+`
+
+func (q *Queries) GetFeedForUpdate(ctx context.Context, id uuid.UUID) (Feed, error) {
+	row := q.db.QueryRowContext(ctx, getFeedForUpdate, id)
+	var i Feed
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Name,
+		&i.Url,
+		&i.UserID,
+		&i.LastFetchedAt,
+		&i.Etag,
+		&i.LastModified,
+		&i.LastError,
+		&i.ConsecutiveFailures,
+		&i.NextFetchAt,
+		&i.LastSuccessAt,
+		&i.LastHttpStatus,
+		&i.ItemCount,
+		&i.SiteUrl,
+		&i.LeasedUntil,
+		&i.UnparseableDates,
+	)
+	return i, err
+}
+
+const getFeeds = `-- name: GetFeeds :many
+SELECT id, created_at, updated_at, name, url, user_id, last_fetched_at, etag, last_modified, last_error, consecutive_failures, next_fetch_at, last_success_at, last_http_status, item_count, site_url, leased_until, unparseable_dates FROM feeds
+`
+
+func (q *Queries) GetFeeds(ctx context.Context) ([]Feed, error) {
+	rows, err := q.db.QueryContext(ctx, getFeeds)
 	if err != nil {
 		return nil, err
 	}
@@ -106,6 +262,17 @@ func (q *Queries) GetFeedsByUser(ctx context.Context, userID uuid.UUID) ([]Feed,
 			&i.Url,
 			&i.UserID,
 			&i.LastFetchedAt,
+			&i.Etag,
+			&i.LastModified,
+			&i.LastError,
+			&i.ConsecutiveFailures,
+			&i.NextFetchAt,
+			&i.LastSuccessAt,
+			&i.LastHttpStatus,
+			&i.ItemCount,
+			&i.SiteUrl,
+			&i.LeasedUntil,
+			&i.UnparseableDates,
 		); err != nil {
 			return nil, err
 		}
@@ -120,14 +287,13 @@ func (q *Queries) GetFeedsByUser(ctx context.Context, userID uuid.UUID) ([]Feed,
 	return items, nil
 }
 
-const getNextFeedsToFetch = `-- name: GetNextFeedsToFetch :many
-SELECT id, created_at, updated_at, name, url, user_id, last_fetched_at FROM feeds
-ORDER BY last_fetched_at ASC NULLS FIRST
-LIMIT $1
+const getFeedsByUser = `-- name: GetFeedsByUser :many
+SELECT id, created_at, updated_at, name, url, user_id, last_fetched_at, etag, last_modified, last_error, consecutive_failures, next_fetch_at, last_success_at, last_http_status, item_count, site_url, leased_until, unparseable_dates FROM feeds
+WHERE user_id = $1
 `
 
-func (q *Queries) GetNextFeedsToFetch(ctx context.Context, limit int32) ([]Feed, error) {
-	rows, err := q.db.QueryContext(ctx, getNextFeedsToFetch, limit)
+func (q *Queries) GetFeedsByUser(ctx context.Context, userID uuid.UUID) ([]Feed, error) {
+	rows, err := q.db.QueryContext(ctx, getFeedsByUser, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -143,6 +309,17 @@ func (q *Queries) GetNextFeedsToFetch(ctx context.Context, limit int32) ([]Feed,
 			&i.Url,
 			&i.UserID,
 			&i.LastFetchedAt,
+			&i.Etag,
+			&i.LastModified,
+			&i.LastError,
+			&i.ConsecutiveFailures,
+			&i.NextFetchAt,
+			&i.LastSuccessAt,
+			&i.LastHttpStatus,
+			&i.ItemCount,
+			&i.SiteUrl,
+			&i.LeasedUntil,
+			&i.UnparseableDates,
 		); err != nil {
 			return nil, err
 		}
@@ -157,12 +334,21 @@ func (q *Queries) GetNextFeedsToFetch(ctx context.Context, limit int32) ([]Feed,
 	return items, nil
 }
 
+const lockFeedURL = `-- name: LockFeedURL :exec
+SELECT pg_advisory_xact_lock(hashtext($1::text))
+`
+
+func (q *Queries) LockFeedURL(ctx context.Context, url string) error {
+	_, err := q.db.ExecContext(ctx, lockFeedURL, url)
+	return err
+}
+
 const markFeedAsFetched = `-- name: MarkFeedAsFetched :one
 UPDATE feeds
-SET last_fetched_at = NOW(), 
-updated_at = NOW()
+SET last_fetched_at = NOW() AT TIME ZONE 'UTC',
+updated_at = NOW() AT TIME ZONE 'UTC'
 WHERE id = $1
-RETURNING id, created_at, updated_at, name, url, user_id, last_fetched_at
+RETURNING id, created_at, updated_at, name, url, user_id, last_fetched_at, etag, last_modified, last_error, consecutive_failures, next_fetch_at, last_success_at, last_http_status, item_count, site_url, leased_until, unparseable_dates
 `
 
 func (q *Queries) MarkFeedAsFetched(ctx context.Context, id uuid.UUID) (Feed, error) {
@@ -176,6 +362,186 @@ func (q *Queries) MarkFeedAsFetched(ctx context.Context, id uuid.UUID) (Feed, er
 		&i.Url,
 		&i.UserID,
 		&i.LastFetchedAt,
+		&i.Etag,
+		&i.LastModified,
+		&i.LastError,
+		&i.ConsecutiveFailures,
+		&i.NextFetchAt,
+		&i.LastSuccessAt,
+		&i.LastHttpStatus,
+		&i.ItemCount,
+		&i.SiteUrl,
+		&i.LeasedUntil,
+		&i.UnparseableDates,
+	)
+	return i, err
+}
+
+const markFeedFetchFailed = `-- name: MarkFeedFetchFailed :exec
+UPDATE feeds
+SET last_error = $1,
+consecutive_failures = consecutive_failures + 1,
+next_fetch_at = (NOW() AT TIME ZONE 'UTC') + make_interval(secs => $2::float8),
+last_http_status = $3
+WHERE id = $4
+`
+
+type MarkFeedFetchFailedParams struct {
+	LastError      sql.NullString
+	BackoffSeconds float64
+	LastHttpStatus sql.NullInt32
+	ID             uuid.UUID
+}
+
+func (q *Queries) MarkFeedFetchFailed(ctx context.Context, arg MarkFeedFetchFailedParams) error {
+	_, err := q.db.ExecContext(ctx, markFeedFetchFailed,
+		arg.LastError,
+		arg.BackoffSeconds,
+		arg.LastHttpStatus,
+		arg.ID,
+	)
+	return err
+}
+
+const markFeedFetchSucceeded = `-- name: MarkFeedFetchSucceeded :exec
+UPDATE feeds
+SET last_error = NULL,
+consecutive_failures = 0,
+next_fetch_at = NULL,
+last_success_at = NOW() AT TIME ZONE 'UTC',
+last_http_status = $1,
+item_count = COALESCE($2::integer, item_count),
+unparseable_dates = COALESCE($3::integer, unparseable_dates),
+site_url = COALESCE($4::text, site_url)
+WHERE id = $5
+`
+
+type MarkFeedFetchSucceededParams struct {
+	LastHttpStatus   sql.NullInt32
+	ItemCount        sql.NullInt32
+	UnparseableDates sql.NullInt32
+	SiteUrl          sql.NullString
+	ID               uuid.UUID
+}
+
+func (q *Queries) MarkFeedFetchSucceeded(ctx context.Context, arg MarkFeedFetchSucceededParams) error {
+	_, err := q.db.ExecContext(ctx, markFeedFetchSucceeded,
+		arg.LastHttpStatus,
+		arg.ItemCount,
+		arg.UnparseableDates,
+		arg.SiteUrl,
+		arg.ID,
+	)
+	return err
+}
+
+const releaseFeedLease = `-- name: ReleaseFeedLease :exec
+UPDATE feeds
+SET leased_until = NULL
+WHERE id = $1 AND leased_until = $2
+`
+
+type ReleaseFeedLeaseParams struct {
+	ID          uuid.UUID
+	LeasedUntil sql.NullTime
+}
+
+func (q *Queries) ReleaseFeedLease(ctx context.Context, arg ReleaseFeedLeaseParams) error {
+	_, err := q.db.ExecContext(ctx, releaseFeedLease, arg.ID, arg.LeasedUntil)
+	return err
+}
+
+const transferFeedOwnership = `-- name: TransferFeedOwnership :exec
+UPDATE feeds
+SET user_id = $2,
+updated_at = $3
+WHERE id = $1
+`
+
+type TransferFeedOwnershipParams struct {
+	ID        uuid.UUID
+	UserID    uuid.UUID
+	UpdatedAt time.Time
+}
+
+func (q *Queries) TransferFeedOwnership(ctx context.Context, arg TransferFeedOwnershipParams) error {
+	_, err := q.db.ExecContext(ctx, transferFeedOwnership, arg.ID, arg.UserID, arg.UpdatedAt)
+	return err
+}
+
+const updateFeed = `-- name: UpdateFeed :one
+UPDATE feeds
+SET name = $2,
+url = $3,
+updated_at = $4,
+etag = CASE WHEN url = $3 THEN etag END,
+last_modified = CASE WHEN url = $3 THEN last_modified END,
+last_error = CASE WHEN url = $3 THEN last_error END,
+consecutive_failures = CASE WHEN url = $3 THEN consecutive_failures ELSE 0 END,
+next_fetch_at = CASE WHEN url = $3 THEN next_fetch_at END,
+last_fetched_at = CASE WHEN url = $3 THEN last_fetched_at END,
+last_success_at = CASE WHEN url = $3 THEN last_success_at END,
+last_http_status = CASE WHEN url = $3 THEN last_http_status END,
+item_count = CASE WHEN url = $3 THEN item_count ELSE 0 END,
+unparseable_dates = CASE WHEN url = $3 THEN unparseable_dates ELSE 0 END,
+site_url = CASE WHEN url = $3 THEN site_url END
+WHERE id = $1
+RETURNING id, created_at, updated_at, name, url, user_id, last_fetched_at, etag, last_modified, last_error, consecutive_failures, next_fetch_at, last_success_at, last_http_status, item_count, site_url, leased_until, unparseable_dates
+`
+
+type UpdateFeedParams struct {
+	ID        uuid.UUID
+	Name      string
+	Url       string
+	UpdatedAt time.Time
+}
+
+// A new URL clears everything learned from fetching the old one
+func (q *Queries) UpdateFeed(ctx context.Context, arg UpdateFeedParams) (Feed, error) {
+	row := q.db.QueryRowContext(ctx, updateFeed,
+		arg.ID,
+		arg.Name,
+		arg.Url,
+		arg.UpdatedAt,
+	)
+	var i Feed
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Name,
+		&i.Url,
+		&i.UserID,
+		&i.LastFetchedAt,
+		&i.Etag,
+		&i.LastModified,
+		&i.LastError,
+		&i.ConsecutiveFailures,
+		&i.NextFetchAt,
+		&i.LastSuccessAt,
+		&i.LastHttpStatus,
+		&i.ItemCount,
+		&i.SiteUrl,
+		&i.LeasedUntil,
+		&i.UnparseableDates,
 	)
 	return i, err
 }
+
+const updateFeedHTTPCache = `-- name: UpdateFeedHTTPCache :exec
+UPDATE feeds
+SET etag = $2,
+last_modified = $3
+WHERE id = $1
+`
+
+type UpdateFeedHTTPCacheParams struct {
+	ID           uuid.UUID
+	Etag         sql.NullString
+	LastModified sql.NullString
+}
+
+func (q *Queries) UpdateFeedHTTPCache(ctx context.Context, arg UpdateFeedHTTPCacheParams) error {
+	_, err := q.db.ExecContext(ctx, updateFeedHTTPCache, arg.ID, arg.Etag, arg.LastModified)
+	return err
+}
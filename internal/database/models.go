@@ -12,13 +12,24 @@ import (
 )
 
 type Feed struct {
-	ID            uuid.UUID
-	CreatedAt     time.Time
-	UpdatedAt     time.Time
-	Name          string
-	Url           string
-	UserID        uuid.UUID
-	LastFetchedAt sql.NullTime
+	ID                  uuid.UUID
+	CreatedAt           time.Time
+	UpdatedAt           time.Time
+	Name                string
+	Url                 string
+	UserID              uuid.UUID
+	LastFetchedAt       sql.NullTime
+	Etag                sql.NullString
+	LastModified        sql.NullString
+	LastError           sql.NullString
+	ConsecutiveFailures int32
+	NextFetchAt         sql.NullTime
+	LastSuccessAt       sql.NullTime
+	LastHttpStatus      sql.NullInt32
+	ItemCount           int32
+	SiteUrl             sql.NullString
+	LeasedUntil         sql.NullTime
+	UnparseableDates    int32
 }
 
 type FeedFollow struct {
@@ -27,17 +38,48 @@ type FeedFollow struct {
 	UpdatedAt time.Time
 	UserID    uuid.UUID
 	FeedID    uuid.UUID
+	Category  sql.NullString
 }
 
 type Post struct {
+	ID           uuid.UUID
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Title        string
+	Description  sql.NullString
+	PublishedAt  time.Time
+	Url          string
+	FeedID       uuid.UUID
+	SearchVector interface{}
+	ContentHash  sql.NullString
+	Guid         string
+}
+
+type PostRead struct {
+	UserID uuid.UUID
+	PostID uuid.UUID
+	ReadAt time.Time
+}
+
+type PostRevision struct {
+	ID          uuid.UUID
+	PostID      uuid.UUID
+	CreatedAt   time.Time
+	Title       string
+	Description sql.NullString
+	PublishedAt time.Time
+}
+
+type PostStar struct {
 	ID          uuid.UUID
 	CreatedAt   time.Time
-	UpdatedAt   time.Time
+	UserID      uuid.UUID
+	PostID      uuid.NullUUID
+	FeedID      uuid.NullUUID
 	Title       string
 	Description sql.NullString
 	PublishedAt time.Time
 	Url         string
-	FeedID      uuid.UUID
 }
 
 type User struct {
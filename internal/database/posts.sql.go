@@ -11,15 +11,110 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
-const createPost = `-- name: CreatePost :one
-INSERT INTO posts (id, created_at, updated_at, title, description, published_at, url, feed_id)
-VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-RETURNING id, created_at, updated_at, title, description, published_at, url, feed_id
+const adoptPostGuids = `-- name: AdoptPostGuids :exec
+UPDATE posts
+SET guid = items.guid
+FROM unnest($1::text[], $2::text[]) AS items(url, guid)
+WHERE posts.feed_id = $3::uuid
+AND posts.guid = posts.url
+AND posts.url = items.url
+AND items.guid <> items.url
+AND NOT EXISTS (
+    SELECT 1 FROM posts existing
+    WHERE existing.feed_id = posts.feed_id
+    AND existing.guid = items.guid
+)
 `
 
-type CreatePostParams struct {
+type AdoptPostGuidsParams struct {
+	Urls   []string
+	Guids  []string
+	FeedID uuid.UUID
+}
+
+// Posts stored before guids were tracked use their url as guid. Once their item is
+// seen again they take its real guid, unless another post in the feed already has it
+func (q *Queries) AdoptPostGuids(ctx context.Context, arg AdoptPostGuidsParams) error {
+	_, err := q.db.ExecContext(ctx, adoptPostGuids, pq.Array(arg.Urls), pq.Array(arg.Guids), arg.FeedID)
+	return err
+}
+
+const countPostsByUser = `-- name: CountPostsByUser :one
+SELECT COUNT(*) FROM posts
+JOIN feed_follows ON feed_follows.feed_id = posts.feed_id
+WHERE feed_follows.user_id = $1
+AND ($2::uuid[] IS NULL OR posts.feed_id = ANY($2::uuid[]))
+AND (
+    $3::timestamp IS NULL
+    OR CASE WHEN $4::boolean THEN posts.created_at ELSE posts.published_at END >= $3::timestamp
+)
+AND (
+    $5::timestamp IS NULL
+    OR CASE WHEN $4::boolean THEN posts.created_at ELSE posts.published_at END < $5::timestamp
+)
+AND (
+    NOT $6::boolean
+    OR NOT EXISTS (SELECT 1 FROM post_reads WHERE post_reads.user_id = feed_follows.user_id AND post_reads.post_id = posts.id)
+)
+`
+
+type CountPostsByUserParams struct {
+	UserID      uuid.UUID
+	FeedIds     []uuid.UUID
+	Since       sql.NullTime
+	ByIngestion bool
+	Until       sql.NullTime
+	UnreadOnly  bool
+}
+
+func (q *Queries) CountPostsByUser(ctx context.Context, arg CountPostsByUserParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countPostsByUser,
+		arg.UserID,
+		pq.Array(arg.FeedIds),
+		arg.Since,
+		arg.ByIngestion,
+		arg.Until,
+		arg.UnreadOnly,
+	)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const getPostsByUser = `-- name: GetPostsByUser :many
+SELECT posts.id, posts.created_at, posts.updated_at, posts.title, posts.description, posts.published_at, posts.url, posts.feed_id FROM posts
+JOIN feed_follows ON feed_follows.feed_id = posts.feed_id
+WHERE feed_follows.user_id = $1
+AND ($2::uuid[] IS NULL OR posts.feed_id = ANY($2::uuid[]))
+AND ($3::timestamp IS NULL OR posts.published_at >= $3::timestamp)
+AND ($4::timestamp IS NULL OR posts.published_at < $4::timestamp)
+AND (
+    NOT $5::boolean
+    OR NOT EXISTS (SELECT 1 FROM post_reads WHERE post_reads.user_id = feed_follows.user_id AND post_reads.post_id = posts.id)
+)
+AND (
+    $6::timestamp IS NULL
+    OR (posts.published_at, posts.id) < ($6::timestamp, $7::uuid)
+)
+ORDER BY posts.published_at DESC, posts.id DESC
+LIMIT $8
+`
+
+type GetPostsByUserParams struct {
+	UserID            uuid.UUID
+	FeedIds           []uuid.UUID
+	Since             sql.NullTime
+	Until             sql.NullTime
+	UnreadOnly        bool
+	CursorPublishedAt sql.NullTime
+	CursorID          uuid.NullUUID
+	Limit             int32
+}
+
+type GetPostsByUserRow struct {
 	ID          uuid.UUID
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
@@ -30,54 +125,179 @@ type CreatePostParams struct {
 	FeedID      uuid.UUID
 }
 
-func (q *Queries) CreatePost(ctx context.Context, arg CreatePostParams) (Post, error) {
-	row := q.db.QueryRowContext(ctx, createPost,
-		arg.ID,
-		arg.CreatedAt,
-		arg.UpdatedAt,
-		arg.Title,
-		arg.Description,
-		arg.PublishedAt,
-		arg.Url,
-		arg.FeedID,
+func (q *Queries) GetPostsByUser(ctx context.Context, arg GetPostsByUserParams) ([]GetPostsByUserRow, error) {
+	rows, err := q.db.QueryContext(ctx, getPostsByUser,
+		arg.UserID,
+		pq.Array(arg.FeedIds),
+		arg.Since,
+		arg.Until,
+		arg.UnreadOnly,
+		arg.CursorPublishedAt,
+		arg.CursorID,
+		arg.Limit,
 	)
-	var i Post
-	err := row.Scan(
-		&i.ID,
-		&i.CreatedAt,
-		&i.UpdatedAt,
-		&i.Title,
-		&i.Description,
-		&i.PublishedAt,
-		&i.Url,
-		&i.FeedID,
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetPostsByUserRow
+	for rows.Next() {
+		var i GetPostsByUserRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Title,
+			&i.Description,
+			&i.PublishedAt,
+			&i.Url,
+			&i.FeedID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getPostsByUserOrderedByIngestion = `-- name: GetPostsByUserOrderedByIngestion :many
+SELECT posts.id, posts.created_at, posts.updated_at, posts.title, posts.description, posts.published_at, posts.url, posts.feed_id FROM posts
+JOIN feed_follows ON feed_follows.feed_id = posts.feed_id
+WHERE feed_follows.user_id = $1
+AND ($2::uuid[] IS NULL OR posts.feed_id = ANY($2::uuid[]))
+AND ($3::timestamp IS NULL OR posts.created_at >= $3::timestamp)
+AND ($4::timestamp IS NULL OR posts.created_at < $4::timestamp)
+AND (
+    NOT $5::boolean
+    OR NOT EXISTS (SELECT 1 FROM post_reads WHERE post_reads.user_id = feed_follows.user_id AND post_reads.post_id = posts.id)
+)
+AND (
+    $6::timestamp IS NULL
+    OR (posts.created_at, posts.id) < ($6::timestamp, $7::uuid)
+)
+ORDER BY posts.created_at DESC, posts.id DESC
+LIMIT $8
+`
+
+type GetPostsByUserOrderedByIngestionParams struct {
+	UserID          uuid.UUID
+	FeedIds         []uuid.UUID
+	Since           sql.NullTime
+	Until           sql.NullTime
+	UnreadOnly      bool
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	Limit           int32
+}
+
+type GetPostsByUserOrderedByIngestionRow struct {
+	ID          uuid.UUID
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	Title       string
+	Description sql.NullString
+	PublishedAt time.Time
+	Url         string
+	FeedID      uuid.UUID
+}
+
+func (q *Queries) GetPostsByUserOrderedByIngestion(ctx context.Context, arg GetPostsByUserOrderedByIngestionParams) ([]GetPostsByUserOrderedByIngestionRow, error) {
+	rows, err := q.db.QueryContext(ctx, getPostsByUserOrderedByIngestion,
+		arg.UserID,
+		pq.Array(arg.FeedIds),
+		arg.Since,
+		arg.Until,
+		arg.UnreadOnly,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.Limit,
 	)
-	return i, err
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetPostsByUserOrderedByIngestionRow
+	for rows.Next() {
+		var i GetPostsByUserOrderedByIngestionRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Title,
+			&i.Description,
+			&i.PublishedAt,
+			&i.Url,
+			&i.FeedID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
-const getPostsByUser = `-- name: GetPostsByUser :many
-SELECT id, created_at, updated_at, title, description, published_at, url, feed_id FROM posts
-WHERE feed_id IN (
-    SELECT id FROM feeds
-    WHERE user_id = $1
-) ORDER BY published_at DESC
-LIMIT $2
+const searchPostsByUser = `-- name: SearchPostsByUser :many
+SELECT posts.id, posts.created_at, posts.updated_at, posts.title, posts.description, posts.published_at, posts.url, posts.feed_id,
+    ts_rank_cd(posts.search_vector, query)::real AS rank,
+    ts_headline('english', posts.title, query, 'HighlightAll=true, StartSel=<mark>, StopSel=</mark>') AS title_highlight,
+    ts_headline('english', coalesce(posts.description, ''), query, 'MaxFragments=2, MaxWords=30, MinWords=10, StartSel=<mark>, StopSel=</mark>') AS snippet
+FROM posts
+JOIN feed_follows ON feed_follows.feed_id = posts.feed_id
+CROSS JOIN to_tsquery('english', $1) AS query
+WHERE feed_follows.user_id = $2
+AND posts.search_vector @@ query
+ORDER BY rank DESC, posts.published_at DESC, posts.id DESC
+LIMIT $3
+OFFSET $4
 `
 
-type GetPostsByUserParams struct {
+type SearchPostsByUserParams struct {
+	Query  string
 	UserID uuid.UUID
 	Limit  int32
+	Offset int32
+}
+
+type SearchPostsByUserRow struct {
+	ID             uuid.UUID
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	Title          string
+	Description    sql.NullString
+	PublishedAt    time.Time
+	Url            string
+	FeedID         uuid.UUID
+	Rank           float32
+	TitleHighlight string
+	Snippet        string
 }
 
-func (q *Queries) GetPostsByUser(ctx context.Context, arg GetPostsByUserParams) ([]Post, error) {
-	rows, err := q.db.QueryContext(ctx, getPostsByUser, arg.UserID, arg.Limit)
+func (q *Queries) SearchPostsByUser(ctx context.Context, arg SearchPostsByUserParams) ([]SearchPostsByUserRow, error) {
+	rows, err := q.db.QueryContext(ctx, searchPostsByUser,
+		arg.Query,
+		arg.UserID,
+		arg.Limit,
+		arg.Offset,
+	)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []Post
+	var items []SearchPostsByUserRow
 	for rows.Next() {
-		var i Post
+		var i SearchPostsByUserRow
 		if err := rows.Scan(
 			&i.ID,
 			&i.CreatedAt,
@@ -87,6 +307,113 @@ func (q *Queries) GetPostsByUser(ctx context.Context, arg GetPostsByUserParams)
 			&i.PublishedAt,
 			&i.Url,
 			&i.FeedID,
+			&i.Rank,
+			&i.TitleHighlight,
+			&i.Snippet,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const upsertPosts = `-- name: UpsertPosts :many
+WITH items AS (
+    SELECT * FROM unnest(
+        $1::uuid[],
+        $2::text[],
+        $3::text[],
+        $4::timestamp[],
+        $5::text[],
+        $6::text[],
+        $7::text[]
+    ) AS items(id, title, description, published_at, url, guid, content_hash)
+),
+previous AS (
+    SELECT posts.id, posts.title, posts.description, posts.published_at, posts.content_hash
+    FROM posts
+    JOIN items ON items.guid = posts.guid
+    WHERE posts.feed_id = $8::uuid
+    AND posts.content_hash IS DISTINCT FROM items.content_hash
+    FOR UPDATE OF posts
+),
+upserted AS (
+    INSERT INTO posts (id, created_at, updated_at, title, description, published_at, url, feed_id, guid, content_hash)
+    SELECT items.id, $9::timestamp, $9::timestamp, items.title, NULLIF(items.description, ''), items.published_at, items.url, $8::uuid, items.guid, items.content_hash
+    FROM items
+    ON CONFLICT (feed_id, guid) DO UPDATE
+    SET title = EXCLUDED.title,
+    description = EXCLUDED.description,
+    published_at = EXCLUDED.published_at,
+    content_hash = EXCLUDED.content_hash,
+    updated_at = EXCLUDED.updated_at
+    WHERE posts.content_hash IS DISTINCT FROM EXCLUDED.content_hash
+    RETURNING posts.id, (xmax = 0) AS inserted
+),
+revisions AS (
+    INSERT INTO post_revisions (id, post_id, created_at, title, description, published_at)
+    SELECT gen_random_uuid(), previous.id, $9::timestamp, previous.title, previous.description, previous.published_at
+    FROM previous
+    JOIN upserted ON upserted.id = previous.id
+    WHERE previous.content_hash IS NOT NULL
+)
+SELECT upserted.id, upserted.inserted, (previous.content_hash IS NOT NULL)::boolean AS revised
+FROM upserted
+LEFT JOIN previous ON previous.id = upserted.id
+`
+
+type UpsertPostsParams struct {
+	Ids           []uuid.UUID
+	Titles        []string
+	Descriptions  []string
+	PublishedAts  []time.Time
+	Urls          []string
+	Guids         []string
+	ContentHashes []string
+	FeedID        uuid.UUID
+	CreatedAt     time.Time
+}
+
+type UpsertPostsRow struct {
+	ID       uuid.UUID
+	Inserted bool
+	Revised  bool
+}
+
+// Inserts new posts and updates existing ones whose content hash changed, saving
+// their previous version in post_revisions. Posts are matched by guid within the
+// feed. Posts stored before hashing have no hash yet; they are updated to
+// backfill it but get no revision
+func (q *Queries) UpsertPosts(ctx context.Context, arg UpsertPostsParams) ([]UpsertPostsRow, error) {
+	rows, err := q.db.QueryContext(ctx, upsertPosts,
+		pq.Array(arg.Ids),
+		pq.Array(arg.Titles),
+		pq.Array(arg.Descriptions),
+		pq.Array(arg.PublishedAts),
+		pq.Array(arg.Urls),
+		pq.Array(arg.Guids),
+		pq.Array(arg.ContentHashes),
+		arg.FeedID,
+		arg.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []UpsertPostsRow
+	for rows.Next() {
+		var i UpsertPostsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Inserted,
+			&i.Revised,
 		); err != nil {
 			return nil, err
 		}
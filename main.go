@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"      // For cancellation on shutdown
 	"database/sql" // Standard library for database operations
+	"errors"       // For checking the server's closed error
 	"fmt"          // For formatted I/O
 	"log"          // For logging
 	"net/http"     // For HTTP server functionality
 	"os"           // For environment variables and system operations
+	"os/signal"    // For catching shutdown signals
+	"syscall"      // For the SIGTERM signal
 	"time"
 
 	"github.com/go-chi/chi/v5"                          // HTTP router for handling routes
@@ -18,9 +22,15 @@ import (
 // apiConfig holds all the configuration for our API server
 // This struct is used to pass dependencies to our HTTP handlers
 type apiConfig struct {
-	DB *database.Queries // Database queries interface generated by SQLC
+	DB        *database.Queries // Database queries interface generated by SQLC
+	DBConn    *sql.DB           // Underlying connection pool, used to start transactions
+	Refresher *feedRefresher    // Runs on-demand feed refreshes
 }
 
+// shutdownTimeout bounds how long in-flight requests and feed fetches
+// get to finish once a shutdown signal is received
+const shutdownTimeout = 30 * time.Second
+
 func main() {
 	// Load environment variables from .env file
 	// This will set up our configuration like database URL and port
@@ -51,20 +61,41 @@ func main() {
 		log.Fatal("Cannot connect to db:", err)
 	}
 
+	// Cancelled on SIGINT or SIGTERM to start a graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Feed fetches run on their own context, which is only cancelled once
+	// shutdownTimeout has passed, so a shutdown lets in-flight fetches finish
+	fetchCtx, cancelFetches := context.WithCancel(context.Background())
+	defer cancelFetches()
+
 	// Create a new database queries instance
 	// This gives us type-safe database operations
 	queries := database.New(conn)
 
+	// The scraper runs in the background and serves on-demand refreshes
+	feedScraper := newScraper(queries)
+
 	// Initialize API configuration with our database connection
 	apiCfg := apiConfig{
-		DB: queries,
+		DB:        queries,
+		DBConn:    conn,
+		Refresher: newFeedRefresher(fetchCtx, feedScraper, feedRefreshInterval),
 	}
 
-	go startScrapping(
-		queries,
-		10,
-		time.Minute,
-	)
+	// Run the scraper until shutdown, scraperDone is closed once it has drained
+	scraperDone := make(chan struct{})
+	go func() {
+		defer close(scraperDone)
+		startScrapping(
+			ctx,
+			fetchCtx,
+			feedScraper,
+			10,
+			time.Minute,
+		)
+	}()
 
 	// Create a new Chi router
 	// This will handle all our HTTP routing
@@ -74,9 +105,9 @@ func main() {
 	// This allows our API to be accessed from different origins (domains)
 	router.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"https://*", "http://*"},                                   // Allow all origins
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},                 // Allowed HTTP methods
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},        // Allowed HTTP methods
 		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"}, // Allowed headers
-		ExposedHeaders:   []string{"Link"},                                                    // Headers that can be exposed to the client
+		ExposedHeaders:   []string{"Link", "X-Total-Count"},                                   // Headers that can be exposed to the client
 		AllowCredentials: false,                                                               // Don't allow credentials in CORS requests
 		MaxAge:           300,                                                                 // Cache preflight requests for 5 minutes
 	}))
@@ -96,7 +127,23 @@ func main() {
 	v1Router.Post("/feed_follows", apiCfg.middlewareAuth(apiCfg.HandlerCreateFeedFollow))                  // Feed follow creation endpoint
 	v1Router.Get("/feed_follows", apiCfg.middlewareAuth(apiCfg.HandlerGetFeedFollowsByUser))               // Feed follow retrieval endpoint
 	v1Router.Delete("/feed_follows/{feedFollowID}", apiCfg.middlewareAuth(apiCfg.HandlerDeleteFeedFollow)) // Feed follow deletion endpoint
+	v1Router.Get("/feeds/preview", apiCfg.middlewareAuth(apiCfg.HandlerPreviewFeed))                       // Feed preview endpoint
+	v1Router.Patch("/feeds/{feedID}", apiCfg.middlewareAuth(apiCfg.HandlerUpdateFeed))                     // Feed update endpoint
+	v1Router.Delete("/feeds/{feedID}", apiCfg.middlewareAuth(apiCfg.HandlerDeleteFeed))                    // Feed deletion endpoint
+	v1Router.Post("/feeds/{feedID}/refresh", apiCfg.middlewareAuth(apiCfg.HandlerRefreshFeed))             // Feed refresh endpoint
+	v1Router.Post("/feeds/{feedID}/read", apiCfg.middlewareAuth(apiCfg.HandlerMarkFeedRead))               // Mark feed as read endpoint
 	v1Router.Get("/posts", apiCfg.middlewareAuth(apiCfg.HandlerGetPostsForUser))                           // Post retrieval endpoint
+	v1Router.Get("/posts/search", apiCfg.middlewareAuth(apiCfg.HandlerSearchPosts))                        // Post search endpoint
+	v1Router.Get("/posts/{postID}/revisions", apiCfg.middlewareAuth(apiCfg.HandlerGetPostRevisions))       // Post revision history endpoint
+	v1Router.Post("/posts/read", apiCfg.middlewareAuth(apiCfg.HandlerMarkPostsReadState))                  // Bulk read state endpoint
+	v1Router.Put("/posts/{postID}/read", apiCfg.middlewareAuth(apiCfg.HandlerMarkPostRead))                // Mark post as read endpoint
+	v1Router.Delete("/posts/{postID}/read", apiCfg.middlewareAuth(apiCfg.HandlerMarkPostUnread))           // Mark post as unread endpoint
+	v1Router.Put("/posts/{postID}/star", apiCfg.middlewareAuth(apiCfg.HandlerStarPost))                    // Post star endpoint
+	v1Router.Delete("/posts/{postID}/star", apiCfg.middlewareAuth(apiCfg.HandlerUnstarPost))               // Post unstar endpoint
+	v1Router.Get("/starred", apiCfg.middlewareAuth(apiCfg.HandlerGetStarredPosts))                         // Starred posts retrieval endpoint
+	v1Router.Delete("/starred/{starID}", apiCfg.middlewareAuth(apiCfg.HandlerDeleteStar))                  // Star deletion endpoint
+	v1Router.Post("/opml", apiCfg.middlewareAuth(apiCfg.HandlerImportOPML))                                // OPML import endpoint
+	v1Router.Get("/opml", apiCfg.middlewareAuth(apiCfg.HandlerExportOPML))                                 // OPML export endpoint
 
 	// Mount v1 router under /v1 path
 	// All v1 endpoints will be prefixed with /v1
@@ -112,8 +159,31 @@ func main() {
 	log.Printf("Server starting on port %v", portString)
 
 	// Start the server and listen for incoming requests
-	err = srv.ListenAndServe()
+	go func() {
+		err := srv.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	// Wait for a shutdown signal, then stop accepting requests and let
+	// in-flight requests and feed fetches finish within shutdownTimeout
+	<-ctx.Done()
+	stop()
+	log.Printf("Shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	context.AfterFunc(shutdownCtx, cancelFetches)
+
+	err = srv.Shutdown(shutdownCtx)
 	if err != nil {
-		log.Fatal(err)
+		log.Printf("Error shutting down server: %v", err)
+	}
+
+	select {
+	case <-scraperDone:
+	case <-shutdownCtx.Done():
+		log.Printf("Timed out waiting for the scraper to stop")
 	}
 }
@@ -1,7 +1,9 @@
 package main
 
 import (
-	"time" // For time operations
+	"database/sql" // For nullable column types
+	"fmt"          // For formatting preview warnings
+	"time"         // For time operations
 
 	"github.com/google/uuid"                            // For UUID handling
 	"github.com/ritikarora108/rssagg/internal/database" // Our database package
@@ -30,23 +32,43 @@ func databaseUserToUser(dbUser database.User) User {
 	}
 }
 
+// Feed includes the outcome of the most recent fetches so clients can
+// explain why a feed has no posts without digging through server logs
 type Feed struct {
-	ID        uuid.UUID `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	Name      string    `json:"name"`
-	Url       string    `json:"url"`
-	UserID    uuid.UUID `json:"user_id"`
+	ID                  uuid.UUID  `json:"id"`
+	CreatedAt           time.Time  `json:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at"`
+	Name                string     `json:"name"`
+	Url                 string     `json:"url"`
+	SiteUrl             *string    `json:"site_url"`
+	UserID              uuid.UUID  `json:"user_id"`
+	LastFetchedAt       *time.Time `json:"last_fetched_at"`
+	LastSuccessAt       *time.Time `json:"last_success_at"`
+	LastHTTPStatus      *int32     `json:"last_http_status"`
+	LastError           *string    `json:"last_error"`
+	ConsecutiveFailures int32      `json:"consecutive_failures"`
+	NextFetchAt         *time.Time `json:"next_fetch_at"`
+	ItemCount           int32      `json:"item_count"`
+	UnparseableDates    int32      `json:"unparseable_dates"`
 }
 
 func databaseFeedToFeed(dbFeed database.Feed) Feed {
 	return Feed{
-		ID:        dbFeed.ID,
-		CreatedAt: dbFeed.CreatedAt,
-		UpdatedAt: dbFeed.UpdatedAt,
-		Name:      dbFeed.Name,
-		Url:       dbFeed.Url,
-		UserID:    dbFeed.UserID,
+		ID:                  dbFeed.ID,
+		CreatedAt:           dbFeed.CreatedAt,
+		UpdatedAt:           dbFeed.UpdatedAt,
+		Name:                dbFeed.Name,
+		Url:                 dbFeed.Url,
+		SiteUrl:             nullStringToStringPtr(dbFeed.SiteUrl),
+		UserID:              dbFeed.UserID,
+		LastFetchedAt:       nullTimeToTimePtr(dbFeed.LastFetchedAt),
+		LastSuccessAt:       nullTimeToTimePtr(dbFeed.LastSuccessAt),
+		LastHTTPStatus:      nullInt32ToInt32Ptr(dbFeed.LastHttpStatus),
+		LastError:           nullStringToStringPtr(dbFeed.LastError),
+		ConsecutiveFailures: dbFeed.ConsecutiveFailures,
+		NextFetchAt:         nullTimeToTimePtr(dbFeed.NextFetchAt),
+		ItemCount:           dbFeed.ItemCount,
+		UnparseableDates:    dbFeed.UnparseableDates,
 	}
 }
 
@@ -58,12 +80,169 @@ func databaseFeedsToFeeds(dbFeeds []database.Feed) []Feed {
 	return feeds
 }
 
+// FeedWithFollow is returned when a user subscribes to a feed URL
+type FeedWithFollow struct {
+	Feed       Feed       `json:"feed"`
+	FeedFollow FeedFollow `json:"feed_follow"`
+}
+
+// FeedCandidate is a feed discovered on the page a user submitted
+type FeedCandidate struct {
+	URL    string `json:"url"`
+	Title  string `json:"title"`
+	Format string `json:"format"`
+}
+
+// FeedCandidates is returned instead of creating a feed when a page advertises several
+type FeedCandidates struct {
+	Candidates []FeedCandidate `json:"candidates"`
+}
+
+func feedCandidatesToFeedCandidates(candidates []feedCandidate) FeedCandidates {
+	result := FeedCandidates{Candidates: make([]FeedCandidate, 0, len(candidates))}
+	for _, candidate := range candidates {
+		result.Candidates = append(result.Candidates, FeedCandidate{
+			URL:    candidate.URL,
+			Title:  candidate.Title,
+			Format: candidate.Format,
+		})
+	}
+	return result
+}
+
+// FeedValidationError is returned when a submitted URL can't be subscribed to.
+// HTTPStatus is the status the publisher answered with, if it answered
+type FeedValidationError struct {
+	Error      string `json:"error"`
+	Code       string `json:"code"`
+	HTTPStatus *int   `json:"http_status"`
+}
+
+func feedValidationErrorToFeedValidationError(err *feedValidationError) FeedValidationError {
+	var httpStatus *int
+	if err.StatusCode != 0 {
+		httpStatus = &err.StatusCode
+	}
+	return FeedValidationError{
+		Error:      err.Error(),
+		Code:       err.Code,
+		HTTPStatus: httpStatus,
+	}
+}
+
+// FeedPreview is a feed as it would be ingested, returned without saving anything
+type FeedPreview struct {
+	URL         string            `json:"url"`
+	Format      string            `json:"format"`
+	Title       string            `json:"title"`
+	Link        string            `json:"link"`
+	Description string            `json:"description"`
+	Language    string            `json:"language"`
+	ItemCount   int               `json:"item_count"`
+	Items       []FeedPreviewItem `json:"items"`
+	Warnings    []string          `json:"warnings"`
+}
+
+// FeedPreviewItem is a feed item normalized the way the scraper stores it.
+// PublishedAt is null when none of the item's dates could be parsed
+type FeedPreviewItem struct {
+	Guid        string     `json:"guid"`
+	Title       string     `json:"title"`
+	Url         string     `json:"url"`
+	Description *string    `json:"description"`
+	PublishedAt *time.Time `json:"published_at"`
+}
+
+// rssFeedToFeedPreview maps a parsed feed into a preview with its first limit
+// items. Items whose date can't be parsed are reported in Warnings, since
+// the scraper stores them with the fetch time instead
+func rssFeedToFeedPreview(feedURL string, rssFeed RSSFeed, limit int) FeedPreview {
+	preview := FeedPreview{
+		URL:         feedURL,
+		Format:      rssFeed.Format,
+		Title:       rssFeed.Channel.Title,
+		Link:        rssFeed.Channel.Link,
+		Description: rssFeed.Channel.Description,
+		Language:    rssFeed.Channel.Language,
+		ItemCount:   len(rssFeed.Channel.Item),
+		Items:       []FeedPreviewItem{},
+		Warnings:    append([]string{}, rssFeed.Warnings...),
+	}
+
+	for i, item := range rssFeed.Channel.Item {
+		publishedAt, ok := parsePubDate(item.PubDate, item.DCDate, item.Updated)
+		if !ok {
+			preview.Warnings = append(preview.Warnings, itemDateWarning(i, item))
+		}
+		if i >= limit {
+			continue
+		}
+
+		var publishedAtPtr *time.Time
+		if ok {
+			publishedAt = publishedAt.UTC()
+			publishedAtPtr = &publishedAt
+		}
+		var description *string
+		if item.Description != "" {
+			description = &item.Description
+		}
+		preview.Items = append(preview.Items, FeedPreviewItem{
+			Guid:        itemGUID(item, itemContentHash(item)),
+			Title:       item.Title,
+			Url:         itemURL(item),
+			Description: description,
+			PublishedAt: publishedAtPtr,
+		})
+	}
+	return preview
+}
+
+func itemDateWarning(index int, item RSSItem) string {
+	for _, date := range []string{item.PubDate, item.DCDate, item.Updated} {
+		if date != "" {
+			return fmt.Sprintf("item %v (%q) has an unparseable date %q, the fetch time would be used", index+1, item.Title, date)
+		}
+	}
+	return fmt.Sprintf("item %v (%q) has no date, the fetch time would be used", index+1, item.Title)
+}
+
+// FeedRefreshResult summarises an on-demand refresh of a feed
+type FeedRefreshResult struct {
+	NewPosts     int      `json:"new_posts"`
+	UpdatedPosts int      `json:"updated_posts"`
+	Duplicates   int      `json:"duplicates"`
+	NotModified  bool     `json:"not_modified"`
+	HTTPStatus   *int     `json:"http_status"`
+	Errors       []string `json:"errors"`
+}
+
+func scrapeResultToFeedRefreshResult(result scrapeResult) FeedRefreshResult {
+	var httpStatus *int
+	if result.StatusCode != 0 {
+		httpStatus = &result.StatusCode
+	}
+	errs := result.Errors
+	if errs == nil {
+		errs = []string{}
+	}
+	return FeedRefreshResult{
+		NewPosts:     result.NewPosts,
+		UpdatedPosts: result.UpdatedPosts,
+		Duplicates:   result.Duplicates,
+		NotModified:  result.NotModified,
+		HTTPStatus:   httpStatus,
+		Errors:       errs,
+	}
+}
+
 type FeedFollow struct {
 	ID        uuid.UUID `json:"id"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 	UserID    uuid.UUID `json:"user_id"`
 	FeedID    uuid.UUID `json:"feed_id"`
+	Category  *string   `json:"category"`
 }
 
 func databaseFeedFollowToFeedFollow(dbFeedFollow database.FeedFollow) FeedFollow {
@@ -73,6 +252,7 @@ func databaseFeedFollowToFeedFollow(dbFeedFollow database.FeedFollow) FeedFollow
 		UpdatedAt: dbFeedFollow.UpdatedAt,
 		UserID:    dbFeedFollow.UserID,
 		FeedID:    dbFeedFollow.FeedID,
+		Category:  nullStringToStringPtr(dbFeedFollow.Category),
 	}
 }
 
@@ -84,6 +264,42 @@ func databaseFeedFollowsToFeedFollows(dbFeedFollows []database.FeedFollow) []Fee
 	return feedFollows
 }
 
+// FeedFollowWithUnreadCount is a feed follow with the number of posts
+// in the feed the user has not read yet
+type FeedFollowWithUnreadCount struct {
+	FeedFollow
+	UnreadCount int64 `json:"unread_count"`
+}
+
+func databaseFeedFollowsWithUnreadCountToFeedFollows(dbFeedFollows []database.GetFeedFollowsWithUnreadCountByUserRow) []FeedFollowWithUnreadCount {
+	feedFollows := []FeedFollowWithUnreadCount{}
+	for _, dbFeedFollow := range dbFeedFollows {
+		feedFollows = append(feedFollows, FeedFollowWithUnreadCount{
+			FeedFollow: FeedFollow{
+				ID:        dbFeedFollow.ID,
+				CreatedAt: dbFeedFollow.CreatedAt,
+				UpdatedAt: dbFeedFollow.UpdatedAt,
+				UserID:    dbFeedFollow.UserID,
+				FeedID:    dbFeedFollow.FeedID,
+				Category:  nullStringToStringPtr(dbFeedFollow.Category),
+			},
+			UnreadCount: dbFeedFollow.UnreadCount,
+		})
+	}
+	return feedFollows
+}
+
+// OPMLImportResult reports the outcome of importing a single OPML subscription.
+// Status is one of created, existing, invalid or failed
+type OPMLImportResult struct {
+	Title    string     `json:"title"`
+	XMLURL   string     `json:"xml_url"`
+	Category string     `json:"category"`
+	Status   string     `json:"status"`
+	FeedID   *uuid.UUID `json:"feed_id"`
+	Error    *string    `json:"error"`
+}
+
 type Post struct {
 	ID          uuid.UUID `json:"id"`
 	CreatedAt   time.Time `json:"created_at"`
@@ -95,29 +311,176 @@ type Post struct {
 	FeedID      uuid.UUID `json:"feed_id"`
 }
 
-func databasePostToPost(dbPost database.Post) Post {
-
-	var description *string
-	if dbPost.Description.Valid {
-		description = &dbPost.Description.String
+func databasePostRowToPost(row database.GetPostsByUserRow) Post {
+	return Post{
+		ID:          row.ID,
+		CreatedAt:   row.CreatedAt,
+		UpdatedAt:   row.UpdatedAt,
+		Title:       row.Title,
+		Description: nullStringToStringPtr(row.Description),
+		PublishedAt: row.PublishedAt,
+		Url:         row.Url,
+		FeedID:      row.FeedID,
 	}
+}
 
-	return Post{
-		ID:          dbPost.ID,
-		CreatedAt:   dbPost.CreatedAt,
-		UpdatedAt:   dbPost.UpdatedAt,
-		Title:       dbPost.Title,
-		Description: description,
-		PublishedAt: dbPost.PublishedAt,
-		Url:         dbPost.Url,
-		FeedID:      dbPost.FeedID,
+func databasePostRowsToPosts(rows []database.GetPostsByUserRow) []Post {
+	posts := []Post{}
+	for _, row := range rows {
+		posts = append(posts, databasePostRowToPost(row))
 	}
+	return posts
 }
 
-func databasePostsToPosts(dbPosts []database.Post) []Post {
+// Both post listings select the same columns, so their rows convert to one another
+func databaseIngestionRowsToPosts(rows []database.GetPostsByUserOrderedByIngestionRow) []Post {
 	posts := []Post{}
-	for _, dbPost := range dbPosts {
-		posts = append(posts, databasePostToPost(dbPost))
+	for _, row := range rows {
+		posts = append(posts, databasePostRowToPost(database.GetPostsByUserRow(row)))
 	}
 	return posts
 }
+
+// PostRevision is a previous version of a post, saved when the feed changed it.
+// RevisedAt is when the newer version replaced it
+type PostRevision struct {
+	ID          uuid.UUID `json:"id"`
+	PostID      uuid.UUID `json:"post_id"`
+	RevisedAt   time.Time `json:"revised_at"`
+	Title       string    `json:"title"`
+	Description *string   `json:"description"`
+	PublishedAt time.Time `json:"published_at"`
+}
+
+func databasePostRevisionToPostRevision(dbRevision database.PostRevision) PostRevision {
+	return PostRevision{
+		ID:          dbRevision.ID,
+		PostID:      dbRevision.PostID,
+		RevisedAt:   dbRevision.CreatedAt,
+		Title:       dbRevision.Title,
+		Description: nullStringToStringPtr(dbRevision.Description),
+		PublishedAt: dbRevision.PublishedAt,
+	}
+}
+
+func databasePostRevisionsToPostRevisions(dbRevisions []database.PostRevision) []PostRevision {
+	revisions := []PostRevision{}
+	for _, dbRevision := range dbRevisions {
+		revisions = append(revisions, databasePostRevisionToPostRevision(dbRevision))
+	}
+	return revisions
+}
+
+// PostSearchResult is a post matching a search, with <mark> highlighted
+// title and description snippets
+type PostSearchResult struct {
+	Post
+	Rank           float32 `json:"rank"`
+	TitleHighlight string  `json:"title_highlight"`
+	Snippet        string  `json:"snippet"`
+}
+
+func databaseSearchResultToSearchResult(dbResult database.SearchPostsByUserRow) PostSearchResult {
+	return PostSearchResult{
+		Post: Post{
+			ID:          dbResult.ID,
+			CreatedAt:   dbResult.CreatedAt,
+			UpdatedAt:   dbResult.UpdatedAt,
+			Title:       dbResult.Title,
+			Description: nullStringToStringPtr(dbResult.Description),
+			PublishedAt: dbResult.PublishedAt,
+			Url:         dbResult.Url,
+			FeedID:      dbResult.FeedID,
+		},
+		Rank:           dbResult.Rank,
+		TitleHighlight: dbResult.TitleHighlight,
+		Snippet:        dbResult.Snippet,
+	}
+}
+
+func databaseSearchResultsToSearchResults(dbResults []database.SearchPostsByUserRow) []PostSearchResult {
+	results := []PostSearchResult{}
+	for _, dbResult := range dbResults {
+		results = append(results, databaseSearchResultToSearchResult(dbResult))
+	}
+	return results
+}
+
+// PostsPage is one page of a keyset paginated post listing.
+// NextCursor is null on the last page
+type PostsPage struct {
+	Posts      []Post  `json:"posts"`
+	NextCursor *string `json:"next_cursor"`
+}
+
+// StarredPost is a copy of a post taken when it was starred. PostID and
+// FeedID become null once the original post or its feed is deleted
+type StarredPost struct {
+	ID          uuid.UUID  `json:"id"`
+	StarredAt   time.Time  `json:"starred_at"`
+	PostID      *uuid.UUID `json:"post_id"`
+	FeedID      *uuid.UUID `json:"feed_id"`
+	Title       string     `json:"title"`
+	Description *string    `json:"description"`
+	PublishedAt time.Time  `json:"published_at"`
+	Url         string     `json:"url"`
+}
+
+func databasePostStarToStarredPost(dbPostStar database.PostStar) StarredPost {
+	return StarredPost{
+		ID:          dbPostStar.ID,
+		StarredAt:   dbPostStar.CreatedAt,
+		PostID:      nullUUIDToUUIDPtr(dbPostStar.PostID),
+		FeedID:      nullUUIDToUUIDPtr(dbPostStar.FeedID),
+		Title:       dbPostStar.Title,
+		Description: nullStringToStringPtr(dbPostStar.Description),
+		PublishedAt: dbPostStar.PublishedAt,
+		Url:         dbPostStar.Url,
+	}
+}
+
+func databasePostStarsToStarredPosts(dbPostStars []database.PostStar) []StarredPost {
+	starredPosts := []StarredPost{}
+	for _, dbPostStar := range dbPostStars {
+		starredPosts = append(starredPosts, databasePostStarToStarredPost(dbPostStar))
+	}
+	return starredPosts
+}
+
+// StarredPostsPage is one page of the user's starred posts.
+// NextCursor is null on the last page
+type StarredPostsPage struct {
+	Posts      []StarredPost `json:"posts"`
+	NextCursor *string       `json:"next_cursor"`
+}
+
+// The helpers below map nullable database columns to pointers, which
+// encode as null in JSON responses when the column is NULL
+
+func nullStringToStringPtr(s sql.NullString) *string {
+	if !s.Valid {
+		return nil
+	}
+	return &s.String
+}
+
+func nullTimeToTimePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+func nullInt32ToInt32Ptr(i sql.NullInt32) *int32 {
+	if !i.Valid {
+		return nil
+	}
+	return &i.Int32
+}
+
+func nullUUIDToUUIDPtr(u uuid.NullUUID) *uuid.UUID {
+	if !u.Valid {
+		return nil
+	}
+	return &u.UUID
+}
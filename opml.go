@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/xml"
+	"strings"
+	"time"
+
+	"github.com/ritikarora108/rssagg/internal/database"
+)
+
+// OPML is an OPML 2.0 subscription list
+type OPML struct {
+	XMLName xml.Name `xml:"opml"`
+	Version string   `xml:"version,attr"`
+	Head    OPMLHead `xml:"head"`
+	Body    OPMLBody `xml:"body"`
+}
+
+type OPMLHead struct {
+	Title       string `xml:"title,omitempty"`
+	DateCreated string `xml:"dateCreated,omitempty"`
+}
+
+type OPMLBody struct {
+	Outlines []OPMLOutline `xml:"outline"`
+}
+
+// OPMLOutline is either a subscription, when XMLURL is set, or a folder of nested outlines
+type OPMLOutline struct {
+	Text     string        `xml:"text,attr"`
+	Title    string        `xml:"title,attr,omitempty"`
+	Type     string        `xml:"type,attr,omitempty"`
+	XMLURL   string        `xml:"xmlUrl,attr,omitempty"`
+	HTMLURL  string        `xml:"htmlUrl,attr,omitempty"`
+	Outlines []OPMLOutline `xml:"outline"`
+}
+
+// opmlCategorySeparator joins nested folder names into a single feed follow category
+const opmlCategorySeparator = "/"
+
+// opmlSubscription is a subscription outline flattened out of its folders
+type opmlSubscription struct {
+	Outline  OPMLOutline
+	Category string
+}
+
+// flattenOPMLOutlines walks the outline tree and returns every subscription
+// with the path of the folders containing it as its category
+func flattenOPMLOutlines(outlines []OPMLOutline, category string) []opmlSubscription {
+	subscriptions := []opmlSubscription{}
+	for _, outline := range outlines {
+		if outline.XMLURL != "" || len(outline.Outlines) == 0 {
+			subscriptions = append(subscriptions, opmlSubscription{
+				Outline:  outline,
+				Category: category,
+			})
+			continue
+		}
+
+		folder := strings.TrimSpace(outline.Title)
+		if folder == "" {
+			folder = strings.TrimSpace(outline.Text)
+		}
+		nested := category
+		if folder != "" {
+			if nested != "" {
+				nested += opmlCategorySeparator
+			}
+			nested += folder
+		}
+		subscriptions = append(subscriptions, flattenOPMLOutlines(outline.Outlines, nested)...)
+	}
+	return subscriptions
+}
+
+// name returns the outline's display name, falling back to its feed URL
+func (o OPMLOutline) name() string {
+	if title := strings.TrimSpace(o.Title); title != "" {
+		return title
+	}
+	if text := strings.TrimSpace(o.Text); text != "" {
+		return text
+	}
+	return strings.TrimSpace(o.XMLURL)
+}
+
+// buildOPML renders followed feeds as an OPML 2.0 document, turning each
+// category back into (possibly nested) folder outlines
+func buildOPML(title string, feeds []database.GetFollowedFeedsByUserRow) OPML {
+	opml := OPML{
+		Version: "2.0",
+		Head: OPMLHead{
+			Title:       title,
+			DateCreated: time.Now().UTC().Format(time.RFC1123Z),
+		},
+	}
+
+	for _, feed := range feeds {
+		outline := OPMLOutline{
+			Text:    feed.Name,
+			Title:   feed.Name,
+			Type:    "rss",
+			XMLURL:  feed.Url,
+			HTMLURL: feed.SiteUrl.String,
+		}
+
+		outlines := &opml.Body.Outlines
+		if feed.Category.Valid {
+			for _, folder := range strings.Split(feed.Category.String, opmlCategorySeparator) {
+				outlines = opmlFolder(outlines, folder)
+			}
+		}
+		*outlines = append(*outlines, outline)
+	}
+
+	return opml
+}
+
+// opmlFolder returns the children of the named folder outline, creating it if needed
+func opmlFolder(outlines *[]OPMLOutline, name string) *[]OPMLOutline {
+	for i := range *outlines {
+		outline := &(*outlines)[i]
+		if outline.XMLURL == "" && outline.Text == name {
+			return &outline.Outlines
+		}
+	}
+	*outlines = append(*outlines, OPMLOutline{Text: name, Title: name})
+	return &(*outlines)[len(*outlines)-1].Outlines
+}
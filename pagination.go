@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// pageCursor is the position of the last row of a page in a keyset ordered by
+// (timestamp, id). Clients receive it as an opaque base64 string
+type pageCursor struct {
+	Time time.Time
+	ID   uuid.UUID
+}
+
+func encodeCursor(cursor pageCursor) string {
+	raw := cursor.Time.UTC().Format(time.RFC3339Nano) + "," + cursor.ID.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(encoded string) (pageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return pageCursor{}, errors.New("invalid cursor")
+	}
+
+	timePart, idPart, ok := strings.Cut(string(raw), ",")
+	if !ok {
+		return pageCursor{}, errors.New("invalid cursor")
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, timePart)
+	if err != nil {
+		return pageCursor{}, errors.New("invalid cursor")
+	}
+
+	id, err := uuid.Parse(idPart)
+	if err != nil {
+		return pageCursor{}, errors.New("invalid cursor")
+	}
+
+	return pageCursor{Time: t, ID: id}, nil
+}
+
+// parsePageParams reads the limit and cursor query parameters.
+// The cursor is nil when the client asks for the first page
+func parsePageParams(r *http.Request) (int32, *pageCursor, error) {
+	limit, err := parseLimitParam(r)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	cursorStr := r.URL.Query().Get("cursor")
+	if cursorStr == "" {
+		return limit, nil, nil
+	}
+
+	cursor, err := decodeCursor(cursorStr)
+	if err != nil {
+		return 0, nil, err
+	}
+	return limit, &cursor, nil
+}
+
+func parseLimitParam(r *http.Request) (int32, error) {
+	limitStr := r.URL.Query().Get("limit")
+	if limitStr == "" {
+		return defaultPageLimit, nil
+	}
+
+	limit, err := strconv.Atoi(limitStr)
+	if err != nil || limit < 1 || limit > maxPageLimit {
+		return 0, fmt.Errorf("limit must be between 1 and %v", maxPageLimit)
+	}
+	return int32(limit), nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// errPrivateAddress is returned when a fetch would connect to an address that
+// isn't on the public internet
+var errPrivateAddress = errors.New("address is not publicly routable")
+
+// reservedPrefixes are special-purpose ranges that netip doesn't classify
+// as private but that never lead to a public host
+var reservedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+}
+
+// newPublicHTTPClient returns a client that refuses to connect to loopback,
+// private, link-local and other non-public addresses. Feed URLs come from users,
+// so without this they could read internal services and cloud metadata endpoints.
+// The check runs on the resolved address of every connection, so it also covers
+// DNS names pointing inside the network and redirects. Proxies are not used,
+// since the check would only see the proxy's address
+func newPublicHTTPClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   10 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   denyPrivateAddress,
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+	}
+}
+
+// denyPrivateAddress is a net.Dialer Control function, called with the
+// resolved address right before connecting
+func denyPrivateAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if !isPublicAddress(ip) {
+		return fmt.Errorf("%w: %v", errPrivateAddress, ip)
+	}
+	return nil
+}
+
+// isPublicAddress reports whether ip is a unicast address on the public internet
+func isPublicAddress(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return false
+	}
+	for _, prefix := range reservedPrefixes {
+		if prefix.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestIsPublicAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		want    bool
+	}{
+		{address: "93.184.216.34", want: true},
+		{address: "2606:2800:220:1:248:1893:25c8:1946", want: true},
+		{address: "127.0.0.1", want: false},
+		{address: "::1", want: false},
+		{address: "10.1.2.3", want: false},
+		{address: "172.16.0.1", want: false},
+		{address: "192.168.1.1", want: false},
+		{address: "169.254.169.254", want: false},
+		{address: "fe80::1", want: false},
+		{address: "fd00::1", want: false},
+		{address: "100.64.0.1", want: false},
+		{address: "0.0.0.0", want: false},
+		{address: "::", want: false},
+		{address: "224.0.0.1", want: false},
+		{address: "::ffff:127.0.0.1", want: false},
+		{address: "::ffff:169.254.169.254", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := isPublicAddress(netip.MustParseAddr(tt.address)); got != tt.want {
+			t.Errorf("isPublicAddress(%v) = %v, want %v", tt.address, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ritikarora108/rssagg/internal/database"
+)
+
+// feedRefreshInterval is the minimum time between two on-demand refreshes of a feed
+const feedRefreshInterval = 30 * time.Second
+
+// feedRefresher runs on-demand scrapes. Requests for a feed that is already
+// being refreshed wait for that run and share its result, and a feed can't be
+// refreshed again until feedRefreshInterval has passed since its last run.
+// Each run takes the feed's lease like the scraper does, so across instances a
+// feed is still fetched by one at a time and no sooner than the interval allows
+type feedRefresher struct {
+	ctx      context.Context // cancelled on shutdown
+	scraper  *scraper
+	interval time.Duration
+
+	mu       sync.Mutex
+	inFlight map[uuid.UUID]*feedRefreshCall
+	lastRun  map[uuid.UUID]time.Time
+}
+
+type feedRefreshCall struct {
+	done       chan struct{}
+	result     scrapeResult
+	retryAfter time.Duration
+}
+
+func newFeedRefresher(ctx context.Context, scraper *scraper, interval time.Duration) *feedRefresher {
+	return &feedRefresher{
+		ctx:      ctx,
+		scraper:  scraper,
+		interval: interval,
+		inFlight: map[uuid.UUID]*feedRefreshCall{},
+		lastRun:  map[uuid.UUID]time.Time{},
+	}
+}
+
+// refresh scrapes the feed now, or joins the refresh already running for it.
+// When the feed was refreshed too recently nothing is scraped and retryAfter
+// is how long the caller has to wait
+func (fr *feedRefresher) refresh(feed database.Feed) (result scrapeResult, retryAfter time.Duration) {
+	fr.mu.Lock()
+	if call, ok := fr.inFlight[feed.ID]; ok {
+		fr.mu.Unlock()
+		<-call.done
+		return call.result, call.retryAfter
+	}
+	if last, ok := fr.lastRun[feed.ID]; ok {
+		if wait := fr.interval - time.Since(last); wait > 0 {
+			fr.mu.Unlock()
+			return scrapeResult{}, wait
+		}
+	}
+	call := &feedRefreshCall{done: make(chan struct{})}
+	fr.inFlight[feed.ID] = call
+	fr.pruneLastRun()
+	fr.lastRun[feed.ID] = time.Now()
+	fr.mu.Unlock()
+
+	call.result, call.retryAfter = fr.run(feed)
+
+	fr.mu.Lock()
+	delete(fr.inFlight, feed.ID)
+	fr.mu.Unlock()
+	close(call.done)
+
+	return call.result, call.retryAfter
+}
+
+// run leases the feed and scrapes it. A feed that another instance is fetching
+// or fetched within the interval isn't scraped, and the caller is asked to wait
+func (fr *feedRefresher) run(feed database.Feed) (scrapeResult, time.Duration) {
+	claimed, err := fr.scraper.claimFeed(fr.ctx, feed.ID, fr.interval)
+	if errors.Is(err, sql.ErrNoRows) {
+		return scrapeResult{}, fr.interval
+	}
+	if err != nil {
+		return scrapeResult{Errors: []string{err.Error()}}, 0
+	}
+	defer fr.scraper.releaseFeedLease(fr.ctx, claimed)
+
+	return fr.scraper.scrapeFeed(fr.ctx, claimed), 0
+}
+
+// pruneLastRun forgets feeds whose rate limit has expired, so lastRun only holds
+// feeds refreshed within the last interval. fr.mu must be held
+func (fr *feedRefresher) pruneLastRun() {
+	for feedID, last := range fr.lastRun {
+		if time.Since(last) >= fr.interval {
+			delete(fr.lastRun, feedID)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ritikarora108/rssagg/internal/database"
+)
+
+func TestFeedRefresherRateLimit(t *testing.T) {
+	feed := database.Feed{ID: uuid.New(), Url: "/rss.xml"}
+	other := database.Feed{ID: uuid.New(), Url: "/atom.xml"}
+	s, _ := newTestScraper(t, &feed, &other)
+	fr := newFeedRefresher(context.Background(), s, 50*time.Millisecond)
+
+	if _, retryAfter := fr.refresh(feed); retryAfter != 0 {
+		t.Fatalf("first refresh: retryAfter = %v, want 0", retryAfter)
+	}
+	if _, retryAfter := fr.refresh(feed); retryAfter <= 0 {
+		t.Errorf("second refresh: retryAfter = %v, want a wait", retryAfter)
+	}
+
+	// Expired entries are dropped when another feed is refreshed
+	time.Sleep(60 * time.Millisecond)
+	fr.refresh(other)
+
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	if _, ok := fr.lastRun[feed.ID]; ok || len(fr.lastRun) != 1 {
+		t.Errorf("lastRun = %v, want only %v", fr.lastRun, other.ID)
+	}
+}
+
+func TestFeedRefresherSkipsLeasedFeed(t *testing.T) {
+	feed := database.Feed{
+		ID:          uuid.New(),
+		Url:         "/rss.xml",
+		LeasedUntil: sql.NullTime{Time: time.Now().UTC().Add(time.Minute), Valid: true},
+	}
+	s, store := newTestScraper(t, &feed)
+	fr := newFeedRefresher(context.Background(), s, time.Minute)
+
+	result, retryAfter := fr.refresh(feed)
+	if retryAfter <= 0 {
+		t.Errorf("retryAfter = %v, want a wait while another instance holds the lease", retryAfter)
+	}
+	if result.NewPosts != 0 || len(store.posts) != 0 {
+		t.Errorf("leased feed was scraped: %+v", result)
+	}
+	if stored := store.feeds[feed.ID]; stored.LastFetchedAt.Valid {
+		t.Errorf("LastFetchedAt = %v, want unset", stored.LastFetchedAt)
+	}
+}
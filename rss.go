@@ -1,52 +1,500 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
 	"io"
 	"net/http"
+	"regexp"
+	"strings"
 	"time"
 )
 
+var (
+	xhtmlDivPattern  = regexp.MustCompile(`(?s)^<(?:[\w-]+:)?div\b[^>]*>(.*)</(?:[\w-]+:)?div\s*>$`)
+	markupTagPattern = regexp.MustCompile(`(?s)<[^>]*>`)
+)
+
+// Feed formats detected by parseFeed
+const (
+	feedFormatRSS  = "rss"
+	feedFormatAtom = "atom"
+	feedFormatRDF  = "rdf"
+	feedFormatJSON = "json"
+)
+
+// RSSFeed is the normalized feed model the scraper consumes.
+// RSS 2.0 documents unmarshal into it directly, other formats are mapped into it
 type RSSFeed struct {
+	Format   string   `xml:"-"`
+	Warnings []string `xml:"-"` // problems found while decoding the document
+	Channel  struct {
+		Title       string    `xml:"title"`
+		Link        string    `xml:"link"`
+		Description string    `xml:"description"`
+		Language    string    `xml:"language"`
+		Item        []RSSItem `xml:"item"`
+	} `xml:"channel"`
+}
+
+type RSSItem struct {
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	Description string `xml:"description"`
+	PubDate     string `xml:"pubDate"`
+	DCDate      string `xml:"http://purl.org/dc/elements/1.1/ date"`
+	Updated     string `xml:"http://www.w3.org/2005/Atom updated"`
+	GUID        string `xml:"guid"`
+}
+
+// AtomFeed is the root <feed> element of an Atom 1.0 document
+type AtomFeed struct {
+	Title    AtomText    `xml:"title"`
+	Subtitle AtomText    `xml:"subtitle"`
+	Links    []AtomLink  `xml:"link"`
+	Entries  []AtomEntry `xml:"entry"`
+}
+
+type AtomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+// AtomText holds an Atom text construct, which may carry escaped html or inline xhtml
+type AtomText struct {
+	Type  string `xml:"type,attr"`
+	Text  string `xml:",chardata"`
+	Inner string `xml:",innerxml"`
+}
+
+type AtomEntry struct {
+	ID        string     `xml:"id"`
+	Title     AtomText   `xml:"title"`
+	Links     []AtomLink `xml:"link"`
+	Summary   AtomText   `xml:"summary"`
+	Content   AtomText   `xml:"content"`
+	Updated   string     `xml:"updated"`
+	Published string     `xml:"published"`
+}
+
+// RDFFeed is the root <rdf:RDF> element of an RSS 1.0 document,
+// where items are siblings of the channel rather than children
+type RDFFeed struct {
 	Channel struct {
-		Title string `xml:"title"`
-		Link string `xml:"link"`
+		Title       string `xml:"title"`
+		Link        string `xml:"link"`
 		Description string `xml:"description"`
-		Language string `xml:"language"`
-		Item []RSSItem `xml:"item"`
+		Language    string `xml:"http://purl.org/dc/elements/1.1/ language"`
 	} `xml:"channel"`
+	Items []RDFItem `xml:"item"`
 }
 
-type RSSItem struct {
-	Title string `xml:"title"`
-	Link string `xml:"link"`
+type RDFItem struct {
+	About       string `xml:"http://www.w3.org/1999/02/22-rdf-syntax-ns# about,attr"`
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
 	Description string `xml:"description"`
-	PubDate string `xml:"pubDate"`
+	Date        string `xml:"http://purl.org/dc/elements/1.1/ date"`
 }
 
-func urlToFeed(url string) (RSSFeed, error) {
-	httpClient := http.Client{
-		Timeout: 10 * time.Second,
-	}
+// JSONFeed is a JSON Feed 1.0 or 1.1 document
+type JSONFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url"`
+	Description string         `json:"description"`
+	Language    string         `json:"language"`
+	Items       []JSONFeedItem `json:"items"`
+}
+
+type JSONFeedItem struct {
+	ID            json.RawMessage `json:"id"`
+	URL           string          `json:"url"`
+	ExternalURL   string          `json:"external_url"`
+	Title         string          `json:"title"`
+	ContentHTML   string          `json:"content_html"`
+	ContentText   string          `json:"content_text"`
+	Summary       string          `json:"summary"`
+	DatePublished string          `json:"date_published"`
+	DateModified  string          `json:"date_modified"`
+}
+
+// feedCacheHeaders are the validators sent back to the publisher on the next
+// fetch so unchanged feeds can answer with 304 Not Modified
+type feedCacheHeaders struct {
+	ETag         string
+	LastModified string
+}
+
+type feedFetchResult struct {
+	Feed        RSSFeed
+	StatusCode  int
+	NotModified bool
+	Cache       feedCacheHeaders
+}
+
+// Fetcher retrieves a raw feed document. It performs a conditional request
+// using cache, reporting an unchanged document through NotModified
+type Fetcher interface {
+	Fetch(ctx context.Context, url string, cache feedCacheHeaders) (fetchedDocument, error)
+}
+
+// Parser turns a fetched document into the normalized feed model
+type Parser interface {
+	Parse(contentType string, data []byte) (RSSFeed, error)
+}
+
+// maxFeedSize caps how much of a response is read, so a huge or endless
+// document can't exhaust memory
+const maxFeedSize = 10 << 20
+
+// errFeedTooLarge is returned for responses larger than maxFeedSize
+var errFeedTooLarge = fmt.Errorf("document is larger than %v MB", maxFeedSize>>20)
+
+// httpFetcher fetches documents over HTTP
+type httpFetcher struct {
+	client *http.Client
+}
+
+// feedParser parses every feed format supported by parseFeed
+type feedParser struct{}
+
+var (
+	defaultFetcher Fetcher = httpFetcher{client: newPublicHTTPClient(10 * time.Second)}
+	defaultParser  Parser  = feedParser{}
+)
 
-	resp, err := httpClient.Get(url)
+func urlToFeed(ctx context.Context, url string) (RSSFeed, error) {
+	result, err := fetchFeed(ctx, url, feedCacheHeaders{})
 	if err != nil {
 		return RSSFeed{}, err
 	}
+	return result.Feed, nil
+}
+
+// fetchedDocument is a response body together with what is needed to interpret it
+type fetchedDocument struct {
+	URL         string // final URL after redirects
+	ContentType string
+	Data        []byte
+	StatusCode  int
+	NotModified bool
+	Cache       feedCacheHeaders
+}
+
+// fetchFeed fetches and parses a feed with the default Fetcher and Parser
+func fetchFeed(ctx context.Context, url string, cache feedCacheHeaders) (feedFetchResult, error) {
+	return fetchAndParseFeed(ctx, defaultFetcher, defaultParser, url, cache)
+}
+
+// fetchAndParseFeed performs a conditional fetch using the cache headers from
+// the previous fetch. A 304 response is reported through NotModified with
+// an empty feed and the previous cache headers. The status code is set
+// whenever the publisher answered, including on error
+func fetchAndParseFeed(ctx context.Context, fetcher Fetcher, parser Parser, url string, cache feedCacheHeaders) (feedFetchResult, error) {
+	doc, err := fetcher.Fetch(ctx, url, cache)
+	if err != nil {
+		return feedFetchResult{StatusCode: doc.StatusCode}, err
+	}
+	if doc.NotModified {
+		return feedFetchResult{StatusCode: doc.StatusCode, NotModified: true, Cache: doc.Cache}, nil
+	}
+
+	rssFeed, err := parser.Parse(doc.ContentType, doc.Data)
+	if err != nil {
+		return feedFetchResult{StatusCode: doc.StatusCode}, err
+	}
+
+	return feedFetchResult{
+		Feed:       rssFeed,
+		StatusCode: doc.StatusCode,
+		Cache:      doc.Cache,
+	}, nil
+}
+
+// fetchDocument fetches a document with the default Fetcher without
+// interpreting the body, so callers can also handle documents that are not feeds
+func fetchDocument(ctx context.Context, url string, cache feedCacheHeaders) (fetchedDocument, error) {
+	return defaultFetcher.Fetch(ctx, url, cache)
+}
+
+func (f httpFetcher) Fetch(ctx context.Context, url string, cache feedCacheHeaders) (fetchedDocument, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fetchedDocument{}, err
+	}
+	if cache.ETag != "" {
+		req.Header.Set("If-None-Match", cache.ETag)
+	}
+	if cache.LastModified != "" {
+		req.Header.Set("If-Modified-Since", cache.LastModified)
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return fetchedDocument{}, err
+	}
 	defer resp.Body.Close()
 
-	data, err := io.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusNotModified {
+		return fetchedDocument{URL: url, StatusCode: resp.StatusCode, NotModified: true, Cache: cache}, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fetchedDocument{StatusCode: resp.StatusCode}, fmt.Errorf("unexpected status code %v", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxFeedSize+1))
+	if err != nil {
+		return fetchedDocument{StatusCode: resp.StatusCode}, err
+	}
+	if len(data) > maxFeedSize {
+		return fetchedDocument{StatusCode: resp.StatusCode}, errFeedTooLarge
+	}
+
+	return fetchedDocument{
+		URL:         resp.Request.URL.String(),
+		ContentType: resp.Header.Get("Content-Type"),
+		Data:        data,
+		StatusCode:  resp.StatusCode,
+		Cache: feedCacheHeaders{
+			ETag:         resp.Header.Get("ETag"),
+			LastModified: resp.Header.Get("Last-Modified"),
+		},
+	}, nil
+}
+
+func (feedParser) Parse(contentType string, data []byte) (RSSFeed, error) {
+	return parseFeed(contentType, data)
+}
+
+// parseFeed detects the document format from the content type and the
+// document itself, and returns it mapped into the normalized RSSFeed model
+func parseFeed(contentType string, data []byte) (RSSFeed, error) {
+	data, warnings := decodeFeedCharset(contentType, data)
+
+	format, err := detectFeedFormat(contentType, data)
 	if err != nil {
 		return RSSFeed{}, err
 	}
 
-	rssFeed := RSSFeed{}
-	err = xml.Unmarshal(data, &rssFeed)
+	var rssFeed RSSFeed
+	switch format {
+	case feedFormatRSS:
+		err = xml.Unmarshal(data, &rssFeed)
+	case feedFormatAtom:
+		atomFeed := AtomFeed{}
+		err = xml.Unmarshal(data, &atomFeed)
+		rssFeed = atomToRSSFeed(atomFeed)
+	case feedFormatRDF:
+		rdfFeed := RDFFeed{}
+		err = xml.Unmarshal(data, &rdfFeed)
+		rssFeed = rdfToRSSFeed(rdfFeed)
+	case feedFormatJSON:
+		jsonFeed := JSONFeed{}
+		err = json.Unmarshal(data, &jsonFeed)
+		rssFeed = jsonFeedToRSSFeed(jsonFeed)
+	}
 	if err != nil {
 		return RSSFeed{}, err
 	}
 
+	rssFeed.Format = format
+	rssFeed.Warnings = warnings
 	return rssFeed, nil
+}
+
+// detectFeedFormat sniffs the feed format. JSON documents are recognised by
+// their content type or leading brace and must carry a JSON Feed version,
+// everything else is treated as xml and identified by its root element
+func detectFeedFormat(contentType string, data []byte) (string, error) {
+	trimmed := bytes.TrimLeft(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")), " \t\r\n")
+
+	if strings.Contains(contentType, "json") || bytes.HasPrefix(trimmed, []byte("{")) {
+		header := struct {
+			Version string `json:"version"`
+		}{}
+		err := json.Unmarshal(trimmed, &header)
+		if err != nil {
+			return "", fmt.Errorf("invalid JSON feed: %v", err)
+		}
+		if !strings.HasPrefix(header.Version, "https://jsonfeed.org/version/") {
+			return "", fmt.Errorf("unsupported JSON feed version %q", header.Version)
+		}
+		return feedFormatJSON, nil
+	}
+
+	root, err := rootElement(trimmed)
+	if err != nil {
+		return "", err
+	}
+
+	switch root.Local {
+	case "rss":
+		return feedFormatRSS, nil
+	case "feed":
+		return feedFormatAtom, nil
+	case "RDF":
+		return feedFormatRDF, nil
+	default:
+		return "", fmt.Errorf("unsupported feed format with root element <%v>", root.Local)
+	}
+}
+
+// rootElement returns the name of the first element in an xml document
+func rootElement(data []byte) (xml.Name, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return xml.Name{}, errors.New("document has no root element")
+			}
+			return xml.Name{}, err
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return start.Name, nil
+		}
+	}
+}
+
+func atomToRSSFeed(atomFeed AtomFeed) RSSFeed {
+	rssFeed := RSSFeed{}
+	rssFeed.Channel.Title = atomFeed.Title.PlainText()
+	rssFeed.Channel.Link = atomAlternateLink(atomFeed.Links)
+	rssFeed.Channel.Description = atomFeed.Subtitle.String()
+
+	for _, entry := range atomFeed.Entries {
+		description := entry.Summary.String()
+		if description == "" {
+			description = entry.Content.String()
+		}
 
-		
+		rssFeed.Channel.Item = append(rssFeed.Channel.Item, RSSItem{
+			Title:       entry.Title.PlainText(),
+			Link:        atomAlternateLink(entry.Links),
+			Description: description,
+			PubDate:     entry.Published,
+			Updated:     entry.Updated,
+			GUID:        strings.TrimSpace(entry.ID),
+		})
+	}
+
+	return rssFeed
+}
+
+func rdfToRSSFeed(rdfFeed RDFFeed) RSSFeed {
+	rssFeed := RSSFeed{}
+	rssFeed.Channel.Title = strings.TrimSpace(rdfFeed.Channel.Title)
+	rssFeed.Channel.Link = strings.TrimSpace(rdfFeed.Channel.Link)
+	rssFeed.Channel.Description = strings.TrimSpace(rdfFeed.Channel.Description)
+	rssFeed.Channel.Language = strings.TrimSpace(rdfFeed.Channel.Language)
+
+	for _, item := range rdfFeed.Items {
+		link := strings.TrimSpace(item.Link)
+		if link == "" {
+			link = item.About
+		}
+
+		rssFeed.Channel.Item = append(rssFeed.Channel.Item, RSSItem{
+			Title:       strings.TrimSpace(item.Title),
+			Link:        link,
+			Description: strings.TrimSpace(item.Description),
+			DCDate:      item.Date,
+			GUID:        item.About,
+		})
+	}
+
+	return rssFeed
+}
+
+func jsonFeedToRSSFeed(jsonFeed JSONFeed) RSSFeed {
+	rssFeed := RSSFeed{}
+	rssFeed.Channel.Title = jsonFeed.Title
+	rssFeed.Channel.Link = jsonFeed.HomePageURL
+	rssFeed.Channel.Description = jsonFeed.Description
+	rssFeed.Channel.Language = jsonFeed.Language
+
+	for _, item := range jsonFeed.Items {
+		link := item.URL
+		if link == "" {
+			link = item.ExternalURL
+		}
+
+		description := item.Summary
+		if description == "" {
+			description = item.ContentHTML
+		}
+		if description == "" {
+			description = item.ContentText
+		}
+
+		rssFeed.Channel.Item = append(rssFeed.Channel.Item, RSSItem{
+			Title:       item.Title,
+			Link:        link,
+			Description: description,
+			PubDate:     item.DatePublished,
+			Updated:     item.DateModified,
+			GUID:        jsonFeedItemID(item.ID),
+		})
+	}
+
+	return rssFeed
+}
+
+// jsonFeedItemID returns an item id as text. ids are strings in JSON Feed 1.1 but
+// some 1.0 publishers emit numbers, which are kept as written so large ids don't
+// lose precision
+func jsonFeedItemID(raw json.RawMessage) string {
+	var id string
+	if json.Unmarshal(raw, &id) == nil {
+		return id
+	}
+	var number json.Number
+	if json.Unmarshal(raw, &number) == nil {
+		return number.String()
+	}
+	return ""
+}
+
+// String returns the content, keeping the markup of html and xhtml constructs.
+// The <div> wrapping xhtml content isn't part of it (RFC 4287 section 3.1.1.3)
+func (t AtomText) String() string {
+	if t.Type == "xhtml" {
+		inner := strings.TrimSpace(t.Inner)
+		if match := xhtmlDivPattern.FindStringSubmatch(inner); match != nil {
+			inner = match[1]
+		}
+		return strings.TrimSpace(inner)
+	}
+	return strings.TrimSpace(t.Text)
+}
+
+// PlainText returns the content with html and xhtml markup reduced to text,
+// for titles, which are stored and shown as plain text
+func (t AtomText) PlainText() string {
+	if t.Type != "html" && t.Type != "xhtml" {
+		return t.String()
+	}
+	text := markupTagPattern.ReplaceAllString(t.String(), "")
+	return strings.Join(strings.Fields(html.UnescapeString(text)), " ")
+}
+
+// atomAlternateLink picks the rel="alternate" link, which is also the default
+// when rel is omitted, falling back to the first link in the list
+func atomAlternateLink(links []AtomLink) string {
+	for _, link := range links {
+		if link.Rel == "" || link.Rel == "alternate" {
+			return link.Href
+		}
+	}
+	if len(links) > 0 {
+		return links[0].Href
+	}
+	return ""
 }
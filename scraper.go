@@ -2,7 +2,10 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"fmt"
 	"log"
 	"strings"
 	"sync"
@@ -12,99 +15,399 @@ import (
 	"github.com/ritikarora108/rssagg/internal/database"
 )
 
+// Failing feeds are retried after feedBackoffBase, doubling with every
+// consecutive failure up to feedBackoffMax
+const (
+	feedBackoffBase = time.Minute
+	feedBackoffMax  = 24 * time.Hour
+)
+
+// feedLeaseDuration is how long a claimed feed stays reserved for the instance
+// that claimed it. The lease is released after the fetch, so this only matters
+// when an instance dies mid-fetch, and must outlast the fetch timeout
+const feedLeaseDuration = 5 * time.Minute
+
+// feedClaimSlack lets a feed be claimed slightly before a full interval has passed
+// since its last fetch, so jitter between ticks doesn't make it skip a round
+const feedClaimSlack = 5 * time.Second
 
+// FeedStore persists what the scraper finds. *database.Queries implements it
+type FeedStore interface {
+	ClaimNextFeedsToFetch(ctx context.Context, arg database.ClaimNextFeedsToFetchParams) ([]database.Feed, error)
+	ClaimFeed(ctx context.Context, arg database.ClaimFeedParams) (database.Feed, error)
+	ReleaseFeedLease(ctx context.Context, arg database.ReleaseFeedLeaseParams) error
+	MarkFeedAsFetched(ctx context.Context, id uuid.UUID) (database.Feed, error)
+	AdoptPostGuids(ctx context.Context, arg database.AdoptPostGuidsParams) error
+	UpsertPosts(ctx context.Context, arg database.UpsertPostsParams) ([]database.UpsertPostsRow, error)
+	UpdateFeedHTTPCache(ctx context.Context, arg database.UpdateFeedHTTPCacheParams) error
+	MarkFeedFetchSucceeded(ctx context.Context, arg database.MarkFeedFetchSucceededParams) error
+	MarkFeedFetchFailed(ctx context.Context, arg database.MarkFeedFetchFailedParams) error
+}
 
+// scraper fetches, parses and stores feeds. Each step goes through an
+// interface so other sources and stores can be plugged in
+type scraper struct {
+	store   FeedStore
+	fetcher Fetcher
+	parser  Parser
+}
 
+// newScraper returns a scraper that fetches feeds over HTTP and stores posts in db
+func newScraper(db *database.Queries) *scraper {
+	return &scraper{
+		store:   db,
+		fetcher: defaultFetcher,
+		parser:  defaultParser,
+	}
+}
+
+// startScrapping fetches the feeds that are due every timeBetweenRequests, up to
+// concurrency at a time, until ctx is cancelled. Fetches run on fetchCtx, so those
+// already started when ctx is cancelled can finish, and it only returns once every
+// scrapeFeed it started has. Feeds are claimed with a lease, so any number of
+// instances can scrape the same database without fetching a feed twice in one interval
 func startScrapping(
-	db *database.Queries,
+	ctx context.Context,
+	fetchCtx context.Context,
+	s *scraper,
 	concurrency int,
 	timeBetweenRequests time.Duration,
 ) {
 	log.Printf("Scrapping on %v goroutines every %s duration", concurrency, timeBetweenRequests)
 
-
 	ticker := time.NewTicker(timeBetweenRequests)
 	defer ticker.Stop()
 
-	for ; ; <-ticker.C {
-		feeds, err := db.GetNextFeedsToFetch(
-			context.Background(),
-			int32(concurrency),
-		)
-		if err != nil {
+	for {
+		feeds, err := s.store.ClaimNextFeedsToFetch(ctx, database.ClaimNextFeedsToFetchParams{
+			LeaseSeconds:    feedLeaseDuration.Seconds(),
+			IntervalSeconds: (timeBetweenRequests - feedClaimSlack).Seconds(),
+			Limit:           int32(concurrency),
+		})
+		if err != nil && ctx.Err() == nil {
 			log.Printf("Error fetching feeds: %v", err)
-			continue
 		}
-		
-		// for _, feed := range feeds {
-		// 	log.Printf("Scrapping feed %v", feed.ID)
-		// 	db.MarkFeedAsFetched(
-		// 		context.Background(),
-		// 		feed.ID,
-		// 	)
-		// }
 
 		wg := &sync.WaitGroup{}
 		for _, feed := range feeds {
 			wg.Add(1)
-			go scrapeFeed(db,wg,feed)
+			go func() {
+				defer wg.Done()
+				s.scrapeFeed(fetchCtx, feed)
+				s.releaseFeedLease(fetchCtx, feed)
+			}()
 		}
 		wg.Wait()
+
+		select {
+		case <-ctx.Done():
+			log.Printf("Scrapping stopped")
+			return
+		case <-ticker.C:
+		}
 	}
 }
 
-func scrapeFeed( db *database.Queries , wg *sync.WaitGroup, feed database.Feed) {
-	defer wg.Done()
-	
+// scrapeResult summarises a single scrapeFeed run
+type scrapeResult struct {
+	NewPosts     int
+	UpdatedPosts int
+	Duplicates   int
+	NotModified  bool
+	StatusCode   int
+	Errors       []string
+}
+
+// scrapeFeed fetches a feed, stores its new and changed posts and records the outcome on the feed.
+// Cancelling ctx aborts the fetch, but once the feed has been fetched its posts
+// are written in full so a shutdown never leaves a half-written batch
+func (s *scraper) scrapeFeed(ctx context.Context, feed database.Feed) scrapeResult {
 	log.Printf("Scrapping feed %v", feed.ID)
-	_,err := db.MarkFeedAsFetched(
-		context.Background(),
+	_, err := s.store.MarkFeedAsFetched(
+		ctx,
 		feed.ID,
 	)
 
 	if err != nil {
 		log.Printf("Error marking feed as fetched: %v", err)
-		return
+		return scrapeResult{Errors: []string{err.Error()}}
 	}
 
-
-	rssFeed, err := urlToFeed(feed.Url)
+	result, err := fetchAndParseFeed(ctx, s.fetcher, s.parser, feed.Url, feedCacheHeaders{
+		ETag:         feed.Etag.String,
+		LastModified: feed.LastModified.String,
+	})
+	// An aborted fetch says nothing about the feed, so it isn't recorded as a failure
+	if err != nil && ctx.Err() != nil {
+		log.Printf("Fetch of feed %v cancelled", feed.ID)
+		return scrapeResult{Errors: []string{ctx.Err().Error()}}
+	}
 	if err != nil {
-		log.Printf("Error fetching feed for %v: %v", feed.Url, err)
-		return
+		s.recordFeedFailure(ctx, feed, result.StatusCode, err)
+		return scrapeResult{StatusCode: result.StatusCode, Errors: []string{err.Error()}}
 	}
 
-	for _, item := range rssFeed.Channel.Item {
+	writeCtx := context.WithoutCancel(ctx)
 
+	summary := scrapeResult{
+		NotModified: result.NotModified,
+		StatusCode:  result.StatusCode,
+		Errors:      []string{},
+	}
 
-		publishedAt, err := time.Parse(time.RFC1123Z, item.PubDate)
-		if err != nil {
-			log.Printf("Couldn't parse date %v with err: %v", item.PubDate, err)
+	// Nothing changed since the last fetch, last_fetched_at has already been advanced
+	if result.NotModified {
+		log.Printf("Feed %v not modified", feed.ID)
+		s.recordFeedSuccess(writeCtx, feed, result, 0)
+		return summary
+	}
+
+	rssFeed := result.Feed
+
+	fetchedAt := time.Now().UTC()
+	unparseableDates := 0
+
+	// All items are upserted in one statement. Posts whose content hash is unchanged
+	// are left alone and only the ids of the inserted or updated ones come back
+	params := database.UpsertPostsParams{
+		CreatedAt: fetchedAt,
+		FeedID:    feed.ID,
+	}
+	seen := map[string]bool{}
+	for _, item := range rssFeed.Channel.Item {
+		item = storableItem(item)
+		contentHash := itemContentHash(item)
+		guid := itemGUID(item, contentHash)
+
+		// A statement can't update the same row twice, so repeated items keep their first occurrence
+		if seen[guid] {
+			summary.Duplicates++
 			continue
 		}
+		seen[guid] = true
 
-		_, err = db.CreatePost(context.Background(),database.CreatePostParams{
-			ID: uuid.New(),
-			CreatedAt: time.Now().UTC(),
-			UpdatedAt: time.Now().UTC(),
-			Title: item.Title,
-			Description: sql.NullString{
-				String: item.Description,
-				Valid: item.Description != "",
-			},
-			PublishedAt: publishedAt,
-			Url: item.Link,
+		// Fall back to the fetch time rather than dropping items with missing or unknown dates
+		publishedAt, ok := parsePubDate(item.PubDate, item.DCDate, item.Updated)
+		if !ok {
+			unparseableDates++
+			publishedAt = fetchedAt
+		}
+
+		params.Ids = append(params.Ids, uuid.New())
+		params.Titles = append(params.Titles, item.Title)
+		params.Descriptions = append(params.Descriptions, item.Description)
+		params.PublishedAts = append(params.PublishedAts, publishedAt.UTC())
+		params.Urls = append(params.Urls, itemURL(item))
+		params.Guids = append(params.Guids, guid)
+		params.ContentHashes = append(params.ContentHashes, contentHash)
+	}
+
+	if len(params.Ids) > 0 {
+		// Posts stored before guids were tracked first take their item's guid, so
+		// the upsert matches them instead of inserting the item again
+		err := s.store.AdoptPostGuids(writeCtx, database.AdoptPostGuidsParams{
+			Urls:   params.Urls,
+			Guids:  params.Guids,
 			FeedID: feed.ID,
 		})
+		var rows []database.UpsertPostsRow
+		if err == nil {
+			rows, err = s.store.UpsertPosts(writeCtx, params)
+		}
 		if err != nil {
-			if(!strings.Contains(err.Error(),"duplicate key value violates unique constraint")) {
-				log.Printf("Couldn't create post for %v: %v", item.Title, err)
+			err = fmt.Errorf("couldn't save posts: %w", err)
+			summary.Errors = append(summary.Errors, err.Error())
+			// The cache headers are left as they were so the next run fetches these posts again
+			s.recordFeedFailure(writeCtx, feed, result.StatusCode, err)
+			return summary
+		}
+		for _, row := range rows {
+			// Posts stored before hashing are only backfilled, they didn't change
+			switch {
+			case row.Inserted:
+				summary.NewPosts++
+			case row.Revised:
+				summary.UpdatedPosts++
 			}
-			continue
 		}
+		summary.Duplicates += len(params.Ids) - summary.NewPosts - summary.UpdatedPosts
+	}
+
+	// Store the validators only once the posts are saved, so a failed run is fetched in full again
+	err = s.store.UpdateFeedHTTPCache(writeCtx, database.UpdateFeedHTTPCacheParams{
+		ID: feed.ID,
+		Etag: sql.NullString{
+			String: result.Cache.ETag,
+			Valid:  result.Cache.ETag != "",
+		},
+		LastModified: sql.NullString{
+			String: result.Cache.LastModified,
+			Valid:  result.Cache.LastModified != "",
+		},
+	})
+	if err != nil {
+		log.Printf("Error saving cache headers for %v: %v", feed.Url, err)
 	}
 
-	log.Printf("Feed %v has %v posts", feed.ID, len(rssFeed.Channel.Item))
+	s.recordFeedSuccess(writeCtx, feed, result, unparseableDates)
 
+	if unparseableDates > 0 {
+		log.Printf("Feed %v has %v posts with unparseable dates, used fetch time instead", feed.ID, unparseableDates)
+	}
+
+	log.Printf("Feed %v has %v posts, %v new, %v updated", feed.ID, len(rssFeed.Channel.Item), summary.NewPosts, summary.UpdatedPosts)
+	return summary
+}
+
+// storableItem strips what Postgres can't keep in a text column, NUL bytes and
+// invalid UTF-8, from the item's stored fields so one bad item can't fail the
+// whole batch
+func storableItem(item RSSItem) RSSItem {
+	item.Title = storableText(item.Title)
+	item.Description = storableText(item.Description)
+	item.Link = storableText(item.Link)
+	item.GUID = storableText(item.GUID)
+	return item
+}
 
+func storableText(text string) string {
+	return strings.ReplaceAll(strings.ToValidUTF8(text, ""), "\x00", "")
+}
+
+// itemContentHash fingerprints the parts of an item that are stored on its post.
+// The raw date string is hashed rather than the parsed time, so an item whose
+// date can't be parsed doesn't look changed on every fetch, and only the date
+// used for published_at counts, so bumping <updated> alone changes nothing
+func itemContentHash(item RSSItem) string {
+	hash := sha256.New()
+	for _, field := range []string{item.Title, item.Description, itemPubDate(item)} {
+		hash.Write([]byte(field))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// itemPubDate returns the date string parsePubDate takes published_at from,
+// or "" when none of the item's dates parse
+func itemPubDate(item RSSItem) string {
+	for _, candidate := range []string{item.PubDate, item.DCDate, item.Updated} {
+		if _, ok := parsePubDate(candidate); ok {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// itemGUID identifies an item within its feed by its guid, or failing that its link.
+// Items with neither are identified by their content, so an edit makes them a new post
+func itemGUID(item RSSItem, contentHash string) string {
+	if guid := strings.TrimSpace(item.GUID); guid != "" {
+		return guid
+	}
+	if link := strings.TrimSpace(item.Link); link != "" {
+		return link
+	}
+	return "sha256:" + contentHash
+}
+
+// itemURL returns the item's link, falling back to a guid that is a web address
+func itemURL(item RSSItem) string {
+	if link := strings.TrimSpace(item.Link); link != "" {
+		return link
+	}
+	guid := strings.TrimSpace(item.GUID)
+	if strings.HasPrefix(guid, "http://") || strings.HasPrefix(guid, "https://") {
+		return guid
+	}
+	return ""
+}
+
+// claimFeed leases a single feed for an on-demand fetch, so no other instance
+// fetches it at the same time. It returns sql.ErrNoRows when the feed is leased
+// elsewhere or was fetched by any instance within minInterval
+func (s *scraper) claimFeed(ctx context.Context, feedID uuid.UUID, minInterval time.Duration) (database.Feed, error) {
+	return s.store.ClaimFeed(ctx, database.ClaimFeedParams{
+		ID:              feedID,
+		LeaseSeconds:    feedLeaseDuration.Seconds(),
+		IntervalSeconds: minInterval.Seconds(),
+	})
+}
+
+// releaseFeedLease lets other instances claim the feed again once it's due.
+// A lease that already expired and was claimed by someone else is left alone
+func (s *scraper) releaseFeedLease(ctx context.Context, feed database.Feed) {
+	err := s.store.ReleaseFeedLease(context.WithoutCancel(ctx), database.ReleaseFeedLeaseParams{
+		ID:          feed.ID,
+		LeasedUntil: feed.LeasedUntil,
+	})
+	if err != nil {
+		log.Printf("Error releasing lease on feed %v: %v", feed.ID, err)
+	}
+}
+
+// recordFeedSuccess clears the feed's failure streak and stores the fetch outcome.
+// The item count, unparseable date count and site URL are kept as they were when
+// the publisher answered 304 Not Modified
+func (s *scraper) recordFeedSuccess(ctx context.Context, feed database.Feed, result feedFetchResult, unparseableDates int) {
+	params := database.MarkFeedFetchSucceededParams{
+		ID:             feed.ID,
+		LastHttpStatus: httpStatusToNullInt32(result.StatusCode),
+	}
+	if !result.NotModified {
+		params.ItemCount = sql.NullInt32{
+			Int32: int32(len(result.Feed.Channel.Item)),
+			Valid: true,
+		}
+		params.UnparseableDates = sql.NullInt32{
+			Int32: int32(unparseableDates),
+			Valid: true,
+		}
+		params.SiteUrl = sql.NullString{
+			String: storableText(result.Feed.Channel.Link),
+			Valid:  storableText(result.Feed.Channel.Link) != "",
+		}
+	}
+
+	err := s.store.MarkFeedFetchSucceeded(ctx, params)
+	if err != nil {
+		log.Printf("Error marking feed fetch as succeeded: %v", err)
+	}
+}
+
+// recordFeedFailure stores the error on the feed and pushes its next fetch back
+// so broken feeds stop taking slots from healthy ones
+func (s *scraper) recordFeedFailure(ctx context.Context, feed database.Feed, statusCode int, fetchErr error) {
+	failures := feed.ConsecutiveFailures + 1
+	backoff := feedBackoff(failures)
+	log.Printf("Error fetching feed for %v (failure %v, retrying in %v): %v", feed.Url, failures, backoff, fetchErr)
+
+	err := s.store.MarkFeedFetchFailed(ctx, database.MarkFeedFetchFailedParams{
+		ID: feed.ID,
+		LastError: sql.NullString{
+			String: fetchErr.Error(),
+			Valid:  true,
+		},
+		BackoffSeconds: backoff.Seconds(),
+		LastHttpStatus: httpStatusToNullInt32(statusCode),
+	})
+	if err != nil {
+		log.Printf("Error marking feed fetch as failed: %v", err)
+	}
+}
+
+// feedBackoff returns how long to wait before fetching a feed again after its nth consecutive failure
+func feedBackoff(failures int32) time.Duration {
+	backoff := feedBackoffBase
+	for i := int32(1); i < failures && backoff < feedBackoffMax; i++ {
+		backoff *= 2
+	}
+	return min(backoff, feedBackoffMax)
+}
+
+// httpStatusToNullInt32 stores a zero status, meaning no response was received, as NULL
+func httpStatusToNullInt32(statusCode int) sql.NullInt32 {
+	return sql.NullInt32{
+		Int32: int32(statusCode),
+		Valid: statusCode != 0,
+	}
 }
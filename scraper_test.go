@@ -0,0 +1,781 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/ritikarora108/rssagg/internal/database"
+)
+
+const fixtureETag = `"fixture-v1"`
+
+// memStore is an in-memory FeedStore. Posts are unique by feed and guid, like the posts table
+type memStore struct {
+	mu        sync.Mutex
+	feeds     map[uuid.UUID]database.Feed
+	posts     map[postKey]database.Post
+	revisions []database.PostRevision
+	upsertErr error // returned by UpsertPosts when set
+}
+
+type postKey struct {
+	FeedID uuid.UUID
+	Guid   string
+}
+
+var _ FeedStore = (*memStore)(nil)
+var _ FeedStore = (*database.Queries)(nil)
+
+func newMemStore(feeds ...database.Feed) *memStore {
+	store := &memStore{
+		feeds: map[uuid.UUID]database.Feed{},
+		posts: map[postKey]database.Post{},
+	}
+	for _, feed := range feeds {
+		store.feeds[feed.ID] = feed
+	}
+	return store
+}
+
+func (m *memStore) ClaimNextFeedsToFetch(ctx context.Context, arg database.ClaimNextFeedsToFetchParams) ([]database.Feed, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC()
+	claimed := []database.Feed{}
+	for id, feed := range m.feeds {
+		if len(claimed) == int(arg.Limit) {
+			break
+		}
+		if feed.NextFetchAt.Valid && feed.NextFetchAt.Time.After(now) {
+			continue
+		}
+		if !claimable(feed, now, arg.IntervalSeconds) {
+			continue
+		}
+		feed.LeasedUntil = nullTimeAfter(now, arg.LeaseSeconds)
+		m.feeds[id] = feed
+		claimed = append(claimed, feed)
+	}
+	return claimed, nil
+}
+
+func (m *memStore) ClaimFeed(ctx context.Context, arg database.ClaimFeedParams) (database.Feed, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC()
+	feed, ok := m.feeds[arg.ID]
+	if !ok || !claimable(feed, now, arg.IntervalSeconds) {
+		return database.Feed{}, sql.ErrNoRows
+	}
+	feed.LeasedUntil = nullTimeAfter(now, arg.LeaseSeconds)
+	m.feeds[arg.ID] = feed
+	return feed, nil
+}
+
+// claimable reports whether a feed is unleased and wasn't fetched within intervalSeconds
+func claimable(feed database.Feed, now time.Time, intervalSeconds float64) bool {
+	fetchedBefore := now.Add(-time.Duration(intervalSeconds * float64(time.Second)))
+	if feed.LastFetchedAt.Valid && feed.LastFetchedAt.Time.After(fetchedBefore) {
+		return false
+	}
+	return !feed.LeasedUntil.Valid || !feed.LeasedUntil.Time.After(now)
+}
+
+func nullTimeAfter(now time.Time, seconds float64) sql.NullTime {
+	return sql.NullTime{Time: now.Add(time.Duration(seconds * float64(time.Second))), Valid: true}
+}
+
+func (m *memStore) ReleaseFeedLease(ctx context.Context, arg database.ReleaseFeedLeaseParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	feed := m.feeds[arg.ID]
+	if feed.LeasedUntil == arg.LeasedUntil {
+		feed.LeasedUntil = sql.NullTime{}
+		m.feeds[arg.ID] = feed
+	}
+	return nil
+}
+
+func (m *memStore) MarkFeedAsFetched(ctx context.Context, id uuid.UUID) (database.Feed, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	feed, ok := m.feeds[id]
+	if !ok {
+		return database.Feed{}, sql.ErrNoRows
+	}
+	feed.LastFetchedAt = sql.NullTime{Time: time.Now().UTC(), Valid: true}
+	m.feeds[id] = feed
+	return feed, nil
+}
+
+func (m *memStore) AdoptPostGuids(ctx context.Context, arg database.AdoptPostGuidsParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, url := range arg.Urls {
+		guid := arg.Guids[i]
+		legacy := postKey{FeedID: arg.FeedID, Guid: url}
+		adopted := postKey{FeedID: arg.FeedID, Guid: guid}
+
+		post, ok := m.posts[legacy]
+		if !ok || post.Url != url || guid == url {
+			continue
+		}
+		if _, taken := m.posts[adopted]; taken {
+			continue
+		}
+		delete(m.posts, legacy)
+		post.Guid = guid
+		m.posts[adopted] = post
+	}
+	return nil
+}
+
+func (m *memStore) UpsertPosts(ctx context.Context, arg database.UpsertPostsParams) ([]database.UpsertPostsRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.upsertErr != nil {
+		return nil, m.upsertErr
+	}
+	// Postgres rejects the whole statement when any text can't be stored
+	for i := range arg.Ids {
+		for _, text := range []string{arg.Titles[i], arg.Descriptions[i], arg.Urls[i], arg.Guids[i]} {
+			if strings.ContainsRune(text, 0) || !utf8.ValidString(text) {
+				return nil, fmt.Errorf("invalid byte sequence for encoding \"UTF8\"")
+			}
+		}
+	}
+
+	rows := []database.UpsertPostsRow{}
+	for i, id := range arg.Ids {
+		post := database.Post{
+			ID:          id,
+			CreatedAt:   arg.CreatedAt,
+			UpdatedAt:   arg.CreatedAt,
+			Title:       arg.Titles[i],
+			Description: sql.NullString{String: arg.Descriptions[i], Valid: arg.Descriptions[i] != ""},
+			PublishedAt: arg.PublishedAts[i],
+			Url:         arg.Urls[i],
+			FeedID:      arg.FeedID,
+			Guid:        arg.Guids[i],
+			ContentHash: sql.NullString{String: arg.ContentHashes[i], Valid: true},
+		}
+
+		key := postKey{FeedID: arg.FeedID, Guid: arg.Guids[i]}
+		previous, ok := m.posts[key]
+		if !ok {
+			m.posts[key] = post
+			rows = append(rows, database.UpsertPostsRow{ID: id, Inserted: true})
+			continue
+		}
+		if previous.ContentHash == post.ContentHash {
+			continue
+		}
+
+		post.ID = previous.ID
+		post.CreatedAt = previous.CreatedAt
+		post.Url = previous.Url
+		m.posts[key] = post
+		if previous.ContentHash.Valid {
+			m.revisions = append(m.revisions, database.PostRevision{
+				ID:          uuid.New(),
+				PostID:      previous.ID,
+				CreatedAt:   arg.CreatedAt,
+				Title:       previous.Title,
+				Description: previous.Description,
+				PublishedAt: previous.PublishedAt,
+			})
+		}
+		rows = append(rows, database.UpsertPostsRow{ID: post.ID, Revised: previous.ContentHash.Valid})
+	}
+	return rows, nil
+}
+
+func (m *memStore) UpdateFeedHTTPCache(ctx context.Context, arg database.UpdateFeedHTTPCacheParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	feed := m.feeds[arg.ID]
+	feed.Etag = arg.Etag
+	feed.LastModified = arg.LastModified
+	m.feeds[arg.ID] = feed
+	return nil
+}
+
+func (m *memStore) MarkFeedFetchSucceeded(ctx context.Context, arg database.MarkFeedFetchSucceededParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	feed := m.feeds[arg.ID]
+	feed.LastError = sql.NullString{}
+	feed.ConsecutiveFailures = 0
+	feed.NextFetchAt = sql.NullTime{}
+	feed.LastSuccessAt = sql.NullTime{Time: time.Now().UTC(), Valid: true}
+	feed.LastHttpStatus = arg.LastHttpStatus
+	if arg.ItemCount.Valid {
+		feed.ItemCount = arg.ItemCount.Int32
+	}
+	if arg.UnparseableDates.Valid {
+		feed.UnparseableDates = arg.UnparseableDates.Int32
+	}
+	if arg.SiteUrl.Valid {
+		feed.SiteUrl = arg.SiteUrl
+	}
+	m.feeds[arg.ID] = feed
+	return nil
+}
+
+func (m *memStore) MarkFeedFetchFailed(ctx context.Context, arg database.MarkFeedFetchFailedParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	feed := m.feeds[arg.ID]
+	feed.LastError = arg.LastError
+	feed.ConsecutiveFailures++
+	feed.NextFetchAt = nullTimeAfter(time.Now().UTC(), arg.BackoffSeconds)
+	feed.LastHttpStatus = arg.LastHttpStatus
+	m.feeds[arg.ID] = feed
+	return nil
+}
+
+// postByURL returns the stored post linking to url
+func (m *memStore) postByURL(url string) (database.Post, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, post := range m.posts {
+		if post.Url == url {
+			return post, true
+		}
+	}
+	return database.Post{}, false
+}
+
+func (m *memStore) postTitles() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	titles := []string{}
+	for _, post := range m.posts {
+		titles = append(titles, post.Title)
+	}
+	slices.Sort(titles)
+	return titles
+}
+
+// newFixtureServer serves the files in testdata. Responses carry fixtureETag
+// and answer 304 when the client already has it. /status/{code} answers with code.
+// The server listens on loopback, so it must be fetched with its own client
+// rather than defaultFetcher
+func newFixtureServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status/{code}", func(w http.ResponseWriter, r *http.Request) {
+		code := http.StatusInternalServerError
+		if r.PathValue("code") == "404" {
+			code = http.StatusNotFound
+		}
+		w.WriteHeader(code)
+	})
+	mux.HandleFunc("GET /{fixture}", func(w http.ResponseWriter, r *http.Request) {
+		data, err := os.ReadFile(filepath.Join("testdata", r.PathValue("fixture")))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("If-None-Match") == fixtureETag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		contentType := "application/xml"
+		if filepath.Ext(r.PathValue("fixture")) == ".json" {
+			contentType = "application/feed+json"
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("ETag", fixtureETag)
+		w.Write(data)
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// newTestScraper returns a scraper fetching from a fixture server into a
+// memStore holding feeds. Each feed's Url is a path on the fixture server and
+// is rewritten to the server's address
+func newTestScraper(t *testing.T, feeds ...*database.Feed) (*scraper, *memStore) {
+	t.Helper()
+
+	srv := newFixtureServer(t)
+	store := newMemStore()
+	for _, feed := range feeds {
+		feed.Url = srv.URL + feed.Url
+		store.feeds[feed.ID] = *feed
+	}
+	return &scraper{store: store, fetcher: httpFetcher{client: srv.Client()}, parser: defaultParser}, store
+}
+
+func TestScrapeFeed(t *testing.T) {
+	tests := []struct {
+		name          string
+		path          string
+		etag          string
+		existingPosts []string
+		wantResult    scrapeResult
+		wantErr       bool
+		wantTitles    []string
+		wantContent   map[string]string // description by post url
+		wantFailures  int32
+		wantSiteURL   string
+		wantETag      string
+	}{
+		{
+			name:        "rss",
+			path:        "/rss.xml",
+			wantResult:  scrapeResult{NewPosts: 3, StatusCode: 200},
+			wantTitles:  []string{"First post", "Second post", "Undated post"},
+			wantSiteURL: "https://example.com/",
+			wantETag:    fixtureETag,
+		},
+		{
+			name:       "atom",
+			path:       "/atom.xml",
+			wantResult: scrapeResult{NewPosts: 2, StatusCode: 200},
+			wantTitles: []string{"Atom entry", "Second entry"},
+			wantContent: map[string]string{
+				"https://atom.example.com/entry":  "An Atom entry",
+				"https://atom.example.com/second": "Inline <b>xhtml</b>",
+			},
+			wantSiteURL: "https://atom.example.com/",
+			wantETag:    fixtureETag,
+		},
+		{
+			name:        "rss 1.0",
+			path:        "/rdf.xml",
+			wantResult:  scrapeResult{NewPosts: 1, StatusCode: 200},
+			wantTitles:  []string{"RDF item"},
+			wantSiteURL: "https://rdf.example.com/",
+			wantETag:    fixtureETag,
+		},
+		{
+			name:        "json feed",
+			path:        "/feed.json",
+			wantResult:  scrapeResult{NewPosts: 2, StatusCode: 200},
+			wantTitles:  []string{"JSON item", "Numeric id"},
+			wantSiteURL: "https://json.example.com/",
+			wantETag:    fixtureETag,
+		},
+		{
+			name:          "posts stored before hashing are backfilled",
+			path:          "/rss.xml",
+			existingPosts: []string{"https://example.com/first", "https://example.com/second"},
+			wantResult:    scrapeResult{NewPosts: 1, Duplicates: 2, StatusCode: 200},
+			wantTitles:    []string{"First post", "Second post", "Undated post"},
+			wantSiteURL:   "https://example.com/",
+			wantETag:      fixtureETag,
+		},
+		{
+			name:          "posts stored before guids adopt their item's guid",
+			path:          "/atom.xml",
+			existingPosts: []string{"https://atom.example.com/entry", "https://atom.example.com/second"},
+			wantResult:    scrapeResult{Duplicates: 2, StatusCode: 200},
+			wantTitles:    []string{"Atom entry", "Second entry"},
+			wantSiteURL:   "https://atom.example.com/",
+			wantETag:      fixtureETag,
+		},
+		{
+			name:        "items without links",
+			path:        "/nolink.xml",
+			wantResult:  scrapeResult{NewPosts: 3, StatusCode: 200},
+			wantTitles:  []string{"All systems operational", "Incident resolved", "Maintenance scheduled"},
+			wantSiteURL: "https://status.example.com/",
+			wantETag:    fixtureETag,
+		},
+		{
+			name:        "repeated links within a feed",
+			path:        "/duplicates.xml",
+			wantResult:  scrapeResult{NewPosts: 2, Duplicates: 1, StatusCode: 200},
+			wantTitles:  []string{"Original", "Other"},
+			wantSiteURL: "https://dup.example.com/",
+			wantETag:    fixtureETag,
+		},
+		{
+			name:       "not modified",
+			path:       "/rss.xml",
+			etag:       fixtureETag,
+			wantResult: scrapeResult{NotModified: true, StatusCode: 304},
+			wantTitles: []string{},
+			wantETag:   fixtureETag,
+		},
+		{
+			name:         "http error",
+			path:         "/status/500",
+			wantResult:   scrapeResult{StatusCode: 500},
+			wantErr:      true,
+			wantTitles:   []string{},
+			wantFailures: 1,
+		},
+		{
+			name:         "not found",
+			path:         "/status/404",
+			wantResult:   scrapeResult{StatusCode: 404},
+			wantErr:      true,
+			wantTitles:   []string{},
+			wantFailures: 1,
+		},
+		{
+			name:         "malformed document",
+			path:         "/broken.xml",
+			wantResult:   scrapeResult{StatusCode: 200},
+			wantErr:      true,
+			wantTitles:   []string{},
+			wantFailures: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			feed := database.Feed{
+				ID:   uuid.New(),
+				Name: tt.name,
+				Url:  tt.path,
+				Etag: sql.NullString{String: tt.etag, Valid: tt.etag != ""},
+			}
+			s, store := newTestScraper(t, &feed)
+			for _, url := range tt.existingPosts {
+				key := postKey{FeedID: feed.ID, Guid: url}
+				store.posts[key] = database.Post{ID: uuid.New(), Title: "Existing", Url: url, FeedID: feed.ID, Guid: url}
+			}
+
+			result := s.scrapeFeed(context.Background(), feed)
+
+			if result.NewPosts != tt.wantResult.NewPosts {
+				t.Errorf("NewPosts = %v, want %v", result.NewPosts, tt.wantResult.NewPosts)
+			}
+			if result.UpdatedPosts != tt.wantResult.UpdatedPosts {
+				t.Errorf("UpdatedPosts = %v, want %v", result.UpdatedPosts, tt.wantResult.UpdatedPosts)
+			}
+			if result.Duplicates != tt.wantResult.Duplicates {
+				t.Errorf("Duplicates = %v, want %v", result.Duplicates, tt.wantResult.Duplicates)
+			}
+			if result.NotModified != tt.wantResult.NotModified {
+				t.Errorf("NotModified = %v, want %v", result.NotModified, tt.wantResult.NotModified)
+			}
+			if result.StatusCode != tt.wantResult.StatusCode {
+				t.Errorf("StatusCode = %v, want %v", result.StatusCode, tt.wantResult.StatusCode)
+			}
+			if gotErr := len(result.Errors) > 0; gotErr != tt.wantErr {
+				t.Errorf("Errors = %v, want errors: %v", result.Errors, tt.wantErr)
+			}
+
+			if titles := store.postTitles(); !slices.Equal(titles, tt.wantTitles) {
+				t.Errorf("stored posts = %q, want %q", titles, tt.wantTitles)
+			}
+			for url, want := range tt.wantContent {
+				if post, _ := store.postByURL(url); post.Description.String != want {
+					t.Errorf("Description of %v = %q, want %q", url, post.Description.String, want)
+				}
+			}
+
+			stored := store.feeds[feed.ID]
+			if stored.ConsecutiveFailures != tt.wantFailures {
+				t.Errorf("ConsecutiveFailures = %v, want %v", stored.ConsecutiveFailures, tt.wantFailures)
+			}
+			if tt.wantFailures > 0 && (!stored.LastError.Valid || !stored.NextFetchAt.Valid) {
+				t.Errorf("failure not recorded: LastError = %v, NextFetchAt = %v", stored.LastError, stored.NextFetchAt)
+			}
+			if stored.SiteUrl.String != tt.wantSiteURL {
+				t.Errorf("SiteUrl = %q, want %q", stored.SiteUrl.String, tt.wantSiteURL)
+			}
+			if stored.Etag.String != tt.wantETag {
+				t.Errorf("Etag = %q, want %q", stored.Etag.String, tt.wantETag)
+			}
+			if int(stored.LastHttpStatus.Int32) != tt.wantResult.StatusCode {
+				t.Errorf("LastHttpStatus = %v, want %v", stored.LastHttpStatus.Int32, tt.wantResult.StatusCode)
+			}
+		})
+	}
+}
+
+func TestScrapeFeedPublishedAt(t *testing.T) {
+	feed := database.Feed{ID: uuid.New(), Url: "/rss.xml"}
+	s, store := newTestScraper(t, &feed)
+
+	before := time.Now().UTC()
+	s.scrapeFeed(context.Background(), feed)
+	after := time.Now().UTC()
+
+	tests := []struct {
+		url  string
+		want time.Time
+	}{
+		{url: "https://example.com/first", want: time.Date(2006, 1, 2, 22, 4, 5, 0, time.UTC)},
+		{url: "https://example.com/second", want: time.Date(2006, 1, 3, 10, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		post, ok := store.postByURL(tt.url)
+		if !ok {
+			t.Errorf("post %v not stored", tt.url)
+			continue
+		}
+		if !post.PublishedAt.Equal(tt.want) || post.PublishedAt.Location() != time.UTC {
+			t.Errorf("PublishedAt of %v = %v, want %v", tt.url, post.PublishedAt, tt.want)
+		}
+	}
+
+	// Undated items fall back to the fetch time and are counted on the feed
+	undated, _ := store.postByURL("https://example.com/undated")
+	if undated.PublishedAt.Before(before) || undated.PublishedAt.After(after) {
+		t.Errorf("PublishedAt of undated post = %v, want between %v and %v", undated.PublishedAt, before, after)
+	}
+	if count := store.feeds[feed.ID].UnparseableDates; count != 1 {
+		t.Errorf("UnparseableDates = %v, want 1", count)
+	}
+}
+
+func TestScrapeFeedUpdatesChangedPosts(t *testing.T) {
+	// revised is the same feed once its publisher edited the document
+	feed := database.Feed{ID: uuid.New(), Url: "/rss.xml"}
+	revised := database.Feed{ID: feed.ID, Url: "/rss-updated.xml"}
+	s, store := newTestScraper(t, &feed, &revised)
+
+	s.scrapeFeed(context.Background(), feed)
+	original, _ := store.postByURL("https://example.com/first")
+
+	// Unchanged items are left alone
+	result := s.scrapeFeed(context.Background(), feed)
+	if result.NewPosts != 0 || result.UpdatedPosts != 0 || result.Duplicates != 3 {
+		t.Errorf("unchanged feed: NewPosts = %v, UpdatedPosts = %v, Duplicates = %v, want 0, 0, 3",
+			result.NewPosts, result.UpdatedPosts, result.Duplicates)
+	}
+	if len(store.revisions) != 0 {
+		t.Errorf("unchanged feed stored %v revisions, want none", len(store.revisions))
+	}
+
+	result = s.scrapeFeed(context.Background(), revised)
+	if result.NewPosts != 0 || result.UpdatedPosts != 1 || result.Duplicates != 2 {
+		t.Errorf("updated feed: NewPosts = %v, UpdatedPosts = %v, Duplicates = %v, want 0, 1, 2",
+			result.NewPosts, result.UpdatedPosts, result.Duplicates)
+	}
+
+	updated, _ := store.postByURL("https://example.com/first")
+	if updated.ID != original.ID || updated.Title != "First post, corrected" {
+		t.Errorf("updated post = %v %q, want %v %q", updated.ID, updated.Title, original.ID, "First post, corrected")
+	}
+
+	if len(store.revisions) != 1 {
+		t.Fatalf("stored %v revisions, want 1", len(store.revisions))
+	}
+	revision := store.revisions[0]
+	if revision.PostID != original.ID || revision.Title != original.Title || revision.Description != original.Description {
+		t.Errorf("revision = %v %q %q, want %v %q %q",
+			revision.PostID, revision.Title, revision.Description.String, original.ID, original.Title, original.Description.String)
+	}
+}
+
+func TestScrapeFeedIgnoresUnstoredDates(t *testing.T) {
+	feed := database.Feed{ID: uuid.New(), Url: "/atom.xml"}
+	revised := database.Feed{ID: feed.ID, Url: "/atom-updated.xml"}
+	s, store := newTestScraper(t, &feed, &revised)
+
+	s.scrapeFeed(context.Background(), feed)
+
+	// The second entry only bumps <updated>, its stored date comes from <published>
+	result := s.scrapeFeed(context.Background(), revised)
+	if result.UpdatedPosts != 0 || result.Duplicates != 2 {
+		t.Errorf("UpdatedPosts = %v, Duplicates = %v, want 0, 2", result.UpdatedPosts, result.Duplicates)
+	}
+	if len(store.revisions) != 0 {
+		t.Errorf("stored %v revisions, want none", len(store.revisions))
+	}
+}
+
+func TestScrapeFeedJSONFeedIDs(t *testing.T) {
+	feed := database.Feed{ID: uuid.New(), Url: "/feed.json"}
+	s, store := newTestScraper(t, &feed)
+
+	s.scrapeFeed(context.Background(), feed)
+
+	// Numeric ids keep their literal text rather than going through float64
+	tests := []struct {
+		url      string
+		wantGuid string
+	}{
+		{url: "https://json.example.com/one", wantGuid: "1"},
+		{url: "https://json.example.com/two", wantGuid: "9007199254740993"},
+	}
+	for _, tt := range tests {
+		post, ok := store.postByURL(tt.url)
+		if !ok {
+			t.Errorf("post %v not stored", tt.url)
+			continue
+		}
+		if post.Guid != tt.wantGuid {
+			t.Errorf("Guid of %v = %q, want %q", tt.url, post.Guid, tt.wantGuid)
+		}
+	}
+}
+
+func TestScrapeFeedUnstorableText(t *testing.T) {
+	feed := database.Feed{ID: uuid.New(), Url: "/nul.json"}
+	s, store := newTestScraper(t, &feed)
+
+	// A NUL byte in one item doesn't fail the batch, it is dropped from the text
+	result := s.scrapeFeed(context.Background(), feed)
+	if result.NewPosts != 2 || len(result.Errors) != 0 {
+		t.Fatalf("result = %+v, want 2 new posts and no errors", result)
+	}
+	post, ok := store.postByURL("https://nul.example.com/one")
+	if !ok {
+		t.Fatal("post with NUL bytes not stored")
+	}
+	if post.Title != "Broken title" || post.Description.String != "Text with a  in it" {
+		t.Errorf("stored Title = %q, Description = %q", post.Title, post.Description.String)
+	}
+	if stored := store.feeds[feed.ID]; stored.LastError.Valid {
+		t.Errorf("LastError = %q, want none", stored.LastError.String)
+	}
+}
+
+func TestScrapeFeedStorageFailure(t *testing.T) {
+	feed := database.Feed{ID: uuid.New(), Url: "/rss.xml"}
+	s, store := newTestScraper(t, &feed)
+	store.upsertErr = errors.New("connection reset")
+
+	// Posts that couldn't be saved are a failure of the feed, not a success
+	result := s.scrapeFeed(context.Background(), feed)
+	if len(result.Errors) != 1 {
+		t.Fatalf("Errors = %v, want one", result.Errors)
+	}
+	stored := store.feeds[feed.ID]
+	if !stored.LastError.Valid || stored.ConsecutiveFailures != 1 || !stored.NextFetchAt.Valid {
+		t.Errorf("failure not recorded: LastError = %v, ConsecutiveFailures = %v, NextFetchAt = %v",
+			stored.LastError, stored.ConsecutiveFailures, stored.NextFetchAt)
+	}
+	if stored.Etag.Valid {
+		t.Errorf("Etag = %q, want unset so the posts are fetched again", stored.Etag.String)
+	}
+}
+
+func TestScrapeFeedSharedLinks(t *testing.T) {
+	first := database.Feed{ID: uuid.New(), Url: "/rss.xml"}
+	second := database.Feed{ID: uuid.New(), Url: "/rss.xml"}
+	s, store := newTestScraper(t, &first, &second)
+
+	// Feeds linking to the same articles each get their own posts
+	for _, feed := range []database.Feed{first, second} {
+		result := s.scrapeFeed(context.Background(), feed)
+		if result.NewPosts != 3 {
+			t.Errorf("NewPosts for feed %v = %v, want 3", feed.ID, result.NewPosts)
+		}
+	}
+	if len(store.posts) != 6 {
+		t.Errorf("stored %v posts, want 6", len(store.posts))
+	}
+}
+
+func TestDefaultFetcherRefusesPrivateAddresses(t *testing.T) {
+	srv := newFixtureServer(t)
+
+	_, err := defaultFetcher.Fetch(context.Background(), srv.URL+"/rss.xml", feedCacheHeaders{})
+	if !errors.Is(err, errPrivateAddress) {
+		t.Errorf("Fetch of a loopback server: err = %v, want %v", err, errPrivateAddress)
+	}
+}
+
+func TestHTTPFetcherLimitsDocumentSize(t *testing.T) {
+	// An endless response is cut off instead of read into memory
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		chunk := make([]byte, 64<<10)
+		for r.Context().Err() == nil {
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	_, err := httpFetcher{client: srv.Client()}.Fetch(context.Background(), srv.URL, feedCacheHeaders{})
+	if !errors.Is(err, errFeedTooLarge) {
+		t.Errorf("Fetch of an endless document: err = %v, want %v", err, errFeedTooLarge)
+	}
+}
+
+func TestStartScrappingLetsFetchesFinish(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "rss.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write(data)
+	}))
+	t.Cleanup(srv.Close)
+
+	feed := database.Feed{ID: uuid.New(), Url: srv.URL}
+	store := newMemStore(feed)
+	s := &scraper{store: store, fetcher: httpFetcher{client: srv.Client()}, parser: defaultParser}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		startScrapping(ctx, context.Background(), s, 1, time.Hour)
+	}()
+
+	// Shut down while the feed is being fetched
+	<-started
+	cancel()
+	select {
+	case <-done:
+		t.Fatal("startScrapping returned before the in-flight fetch finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	<-done
+	if titles := store.postTitles(); len(titles) != 3 {
+		t.Errorf("stored posts = %q, want the 3 fetched posts", titles)
+	}
+}
+
+func TestScrapeFeedCancelled(t *testing.T) {
+	feed := database.Feed{ID: uuid.New(), Url: "/rss.xml"}
+	s, store := newTestScraper(t, &feed)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.scrapeFeed(ctx, feed)
+
+	if titles := store.postTitles(); len(titles) != 0 {
+		t.Errorf("stored posts = %q, want none", titles)
+	}
+	if failures := store.feeds[feed.ID].ConsecutiveFailures; failures != 0 {
+		t.Errorf("ConsecutiveFailures = %v, want a cancelled fetch not to count", failures)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+// buildSearchQuery turns a user search string into a to_tsquery expression.
+// Terms are ANDed together, "quoted phrases" must match as adjacent words,
+// a trailing * makes a term a prefix match and a leading - excludes it.
+// Everything but letters and digits is dropped so the result is always valid tsquery syntax
+func buildSearchQuery(q string) (string, error) {
+	terms := []string{}
+
+	for _, token := range splitSearchTokens(q) {
+		// The - is checked first so -"a phrase" excludes the phrase
+		negated := strings.HasPrefix(token, "-")
+		token = strings.TrimLeft(token, "-")
+		phrase := strings.HasPrefix(token, `"`)
+		if phrase {
+			token = strings.Trim(token, `"`)
+		}
+
+		prefix := !phrase && strings.HasSuffix(token, "*")
+
+		words := strings.FieldsFunc(token, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		if len(words) == 0 {
+			continue
+		}
+		if prefix {
+			words[len(words)-1] += ":*"
+		}
+
+		term := strings.Join(words, " <-> ")
+		if len(words) > 1 {
+			term = "(" + term + ")"
+		}
+		if negated {
+			term = "!" + term
+		}
+		terms = append(terms, term)
+	}
+
+	if len(terms) == 0 {
+		return "", errors.New("search query must contain at least one word")
+	}
+	return strings.Join(terms, " & "), nil
+}
+
+// splitSearchTokens splits on whitespace while keeping "quoted phrases" together,
+// along with a - right before the opening quote
+func splitSearchTokens(q string) []string {
+	tokens := []string{}
+	var current strings.Builder
+	inQuotes := false
+
+	for _, r := range q {
+		switch {
+		case r == '"':
+			if inQuotes {
+				current.WriteRune(r)
+				tokens = append(tokens, current.String())
+				current.Reset()
+			} else {
+				if current.Len() > 0 && strings.Trim(current.String(), "-") != "" {
+					tokens = append(tokens, current.String())
+					current.Reset()
+				}
+				current.WriteRune(r)
+			}
+			inQuotes = !inQuotes
+		case unicode.IsSpace(r) && !inQuotes:
+			if current.Len() > 0 {
+				tokens = append(tokens, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if current.Len() > 0 {
+		tokens = append(tokens, current.String())
+	}
+
+	return tokens
+}
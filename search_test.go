@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+func TestBuildSearchQuery(t *testing.T) {
+	tests := []struct {
+		q       string
+		want    string
+		wantErr bool
+	}{
+		{q: "golang", want: "golang"},
+		{q: "go  channels", want: "go & channels"},
+		{q: `"error handling"`, want: "(error <-> handling)"},
+		{q: `"error handling" go`, want: "(error <-> handling) & go"},
+		{q: `go"error handling"`, want: "go & (error <-> handling)"},
+		{q: "gener*", want: "gener:*"},
+		{q: "-java", want: "!java"},
+		{q: "-gener*", want: "!gener:*"},
+		{q: `-"neg phrase"`, want: "!(neg <-> phrase)"},
+		{q: `go -"neg phrase"`, want: "go & !(neg <-> phrase)"},
+		{q: `"phrase*"`, want: "phrase"},
+		{q: "c++ & | ! (rust)", want: "c & rust"},
+		{q: "e-mail", want: "(e <-> mail)"},
+		{q: `"unclosed phrase`, want: "(unclosed <-> phrase)"},
+		{q: "", wantErr: true},
+		{q: "   ", wantErr: true},
+		{q: `- "" & *`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := buildSearchQuery(tt.q)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("buildSearchQuery(%q) error = %v, want error: %v", tt.q, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("buildSearchQuery(%q) = %q, want %q", tt.q, got, tt.want)
+		}
+	}
+}
@@ -4,6 +4,15 @@ VALUES ($1, $2, $3, $4, $5)
 RETURNING *;
 
 
+-- name: UpsertFeedFollow :one
+INSERT INTO feed_follows (id, created_at, updated_at, user_id, feed_id, category)
+VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT (user_id, feed_id) DO UPDATE
+SET category = COALESCE(EXCLUDED.category, feed_follows.category),
+updated_at = EXCLUDED.updated_at
+RETURNING *;
+
+
 -- name: GetFeedFollow :one
 SELECT * FROM feed_follows WHERE id = $1;
 
@@ -14,3 +23,41 @@ SELECT * FROM feed_follows WHERE user_id = $1;
 -- name: DeleteFeedFollow :exec
 DELETE FROM feed_follows WHERE id = $1 AND user_id = $2;
 
+
+-- name: DeleteFeedFollowByFeed :exec
+DELETE FROM feed_follows WHERE user_id = $1 AND feed_id = $2;
+
+
+-- name: GetNextFeedOwner :one
+SELECT user_id FROM feed_follows
+WHERE feed_id = $1 AND user_id <> $2
+ORDER BY created_at ASC
+LIMIT 1;
+
+
+-- name: GetFeedFollowsWithUnreadCountByUser :many
+SELECT feed_follows.*, (
+    SELECT COUNT(*) FROM posts
+    WHERE posts.feed_id = feed_follows.feed_id
+    AND NOT EXISTS (
+        SELECT 1 FROM post_reads
+        WHERE post_reads.user_id = feed_follows.user_id
+        AND post_reads.post_id = posts.id
+    )
+) AS unread_count
+FROM feed_follows
+WHERE feed_follows.user_id = $1;
+
+
+-- name: GetFollowedFeedsByUser :many
+SELECT feeds.name, feeds.url, feeds.site_url, feed_follows.category
+FROM feed_follows
+JOIN feeds ON feeds.id = feed_follows.feed_id
+WHERE feed_follows.user_id = $1
+ORDER BY feed_follows.category ASC NULLS FIRST, feeds.name ASC;
+
+
+-- name: GetFollowedFeed :one
+SELECT feeds.* FROM feeds
+JOIN feed_follows ON feed_follows.feed_id = feeds.id
+WHERE feeds.id = $1 AND feed_follows.user_id = $2;
@@ -13,20 +13,123 @@ WHERE user_id = $1;
 SELECT * FROM feeds;
 
 
--- name: GetNextFeedsToFetch :many  
+-- name: LockFeedURL :exec
+SELECT pg_advisory_xact_lock(hashtext(sqlc.arg('url')::text));
+
+
+-- name: GetFeedByURL :one
 SELECT * FROM feeds
-ORDER BY last_fetched_at ASC NULLS FIRST
-LIMIT $1;
+WHERE url = $1;
+
+
+-- name: GetFeedForUpdate :one
+SELECT * FROM feeds
+WHERE id = $1
+FOR UPDATE;
+
+
+-- name: UpdateFeed :one
+-- A new URL clears everything learned from fetching the old one
+UPDATE feeds
+SET name = $2,
+url = $3,
+updated_at = $4,
+etag = CASE WHEN url = $3 THEN etag END,
+last_modified = CASE WHEN url = $3 THEN last_modified END,
+last_error = CASE WHEN url = $3 THEN last_error END,
+consecutive_failures = CASE WHEN url = $3 THEN consecutive_failures ELSE 0 END,
+next_fetch_at = CASE WHEN url = $3 THEN next_fetch_at END,
+last_fetched_at = CASE WHEN url = $3 THEN last_fetched_at END,
+last_success_at = CASE WHEN url = $3 THEN last_success_at END,
+last_http_status = CASE WHEN url = $3 THEN last_http_status END,
+item_count = CASE WHEN url = $3 THEN item_count ELSE 0 END,
+unparseable_dates = CASE WHEN url = $3 THEN unparseable_dates ELSE 0 END,
+site_url = CASE WHEN url = $3 THEN site_url END
+WHERE id = $1
+RETURNING *;
+
+
+-- name: TransferFeedOwnership :exec
+UPDATE feeds
+SET user_id = $2,
+updated_at = $3
+WHERE id = $1;
+
+
+-- name: DeleteFeed :exec
+DELETE FROM feeds WHERE id = $1;
+
+
+-- name: ClaimNextFeedsToFetch :many
+-- Fetch times are stored in UTC from the database clock, so every instance
+-- agrees on when a feed is due whatever its own clock or the session time zone
+UPDATE feeds
+SET leased_until = (NOW() AT TIME ZONE 'UTC') + make_interval(secs => sqlc.arg('lease_seconds')::float8)
+WHERE id IN (
+    SELECT id FROM feeds
+    WHERE (next_fetch_at IS NULL OR next_fetch_at <= NOW() AT TIME ZONE 'UTC')
+    AND (last_fetched_at IS NULL OR last_fetched_at <= (NOW() AT TIME ZONE 'UTC') - make_interval(secs => sqlc.arg('interval_seconds')::float8))
+    AND (leased_until IS NULL OR leased_until <= NOW() AT TIME ZONE 'UTC')
+    ORDER BY last_fetched_at ASC NULLS FIRST
+    LIMIT sqlc.arg('limit')
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+
+-- name: ClaimFeed :one
+-- Leases a single feed for an on-demand fetch. Nothing is returned when another
+-- instance holds its lease or it was fetched within interval_seconds
+UPDATE feeds
+SET leased_until = (NOW() AT TIME ZONE 'UTC') + make_interval(secs => sqlc.arg('lease_seconds')::float8)
+WHERE id = sqlc.arg('id')
+AND (last_fetched_at IS NULL OR last_fetched_at <= (NOW() AT TIME ZONE 'UTC') - make_interval(secs => sqlc.arg('interval_seconds')::float8))
+AND (leased_until IS NULL OR leased_until <= NOW() AT TIME ZONE 'UTC')
+RETURNING *;
+
+-- name: ReleaseFeedLease :exec
+UPDATE feeds
+SET leased_until = NULL
+WHERE id = $1 AND leased_until = $2;
 
 
 -- name: MarkFeedAsFetched :one
 UPDATE feeds
-SET last_fetched_at = NOW(), 
-updated_at = NOW()
+SET last_fetched_at = NOW() AT TIME ZONE 'UTC',
+updated_at = NOW() AT TIME ZONE 'UTC'
 WHERE id = $1
 RETURNING *;
 
 
+-- name: MarkFeedFetchFailed :exec
+UPDATE feeds
+SET last_error = sqlc.narg('last_error'),
+consecutive_failures = consecutive_failures + 1,
+next_fetch_at = (NOW() AT TIME ZONE 'UTC') + make_interval(secs => sqlc.arg('backoff_seconds')::float8),
+last_http_status = sqlc.narg('last_http_status')
+WHERE id = sqlc.arg('id');
+
+
+-- name: MarkFeedFetchSucceeded :exec
+UPDATE feeds
+SET last_error = NULL,
+consecutive_failures = 0,
+next_fetch_at = NULL,
+last_success_at = NOW() AT TIME ZONE 'UTC',
+last_http_status = sqlc.narg('last_http_status'),
+item_count = COALESCE(sqlc.narg('item_count')::integer, item_count),
+unparseable_dates = COALESCE(sqlc.narg('unparseable_dates')::integer, unparseable_dates),
+site_url = COALESCE(sqlc.narg('site_url')::text, site_url)
+WHERE id = sqlc.arg('id');
+
+
+-- name: UpdateFeedHTTPCache :exec
+UPDATE feeds
+SET etag = $2,
+last_modified = $3
+WHERE id = $1;
+
+
 
 
 
@@ -0,0 +1,25 @@
+-- name: MarkPostsRead :execrows
+INSERT INTO post_reads (user_id, post_id, read_at)
+SELECT feed_follows.user_id, posts.id, sqlc.arg('read_at')::timestamp
+FROM posts
+JOIN feed_follows ON feed_follows.feed_id = posts.feed_id
+WHERE feed_follows.user_id = sqlc.arg('user_id')
+AND posts.id = ANY(sqlc.arg('post_ids')::uuid[])
+ON CONFLICT (user_id, post_id) DO NOTHING;
+
+
+-- name: MarkPostsUnread :execrows
+DELETE FROM post_reads
+WHERE user_id = sqlc.arg('user_id')
+AND post_id = ANY(sqlc.arg('post_ids')::uuid[]);
+
+
+-- name: MarkFeedPostsReadUntil :execrows
+INSERT INTO post_reads (user_id, post_id, read_at)
+SELECT feed_follows.user_id, posts.id, sqlc.arg('read_at')::timestamp
+FROM posts
+JOIN feed_follows ON feed_follows.feed_id = posts.feed_id
+WHERE feed_follows.user_id = sqlc.arg('user_id')
+AND posts.feed_id = sqlc.arg('feed_id')
+AND posts.published_at <= sqlc.arg('until')::timestamp
+ON CONFLICT (user_id, post_id) DO NOTHING;
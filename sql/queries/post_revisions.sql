@@ -0,0 +1,7 @@
+-- name: GetPostRevisionsByUser :many
+SELECT post_revisions.* FROM post_revisions
+JOIN posts ON posts.id = post_revisions.post_id
+JOIN feed_follows ON feed_follows.feed_id = posts.feed_id
+WHERE post_revisions.post_id = $1
+AND feed_follows.user_id = $2
+ORDER BY post_revisions.created_at DESC;
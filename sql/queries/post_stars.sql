@@ -0,0 +1,30 @@
+-- name: StarPost :one
+INSERT INTO post_stars (id, created_at, user_id, post_id, feed_id, title, description, published_at, url)
+SELECT sqlc.arg('id')::uuid, sqlc.arg('created_at')::timestamp, feed_follows.user_id, posts.id, posts.feed_id, posts.title, posts.description, posts.published_at, posts.url
+FROM posts
+JOIN feed_follows ON feed_follows.feed_id = posts.feed_id
+WHERE posts.id = sqlc.arg('post_id')
+AND feed_follows.user_id = sqlc.arg('user_id')
+ON CONFLICT (user_id, post_id) DO UPDATE SET created_at = post_stars.created_at
+RETURNING *;
+
+
+-- name: UnstarPost :execrows
+DELETE FROM post_stars
+WHERE user_id = $1 AND post_id = $2;
+
+
+-- name: DeleteStar :execrows
+DELETE FROM post_stars
+WHERE id = $1 AND user_id = $2;
+
+
+-- name: GetStarredPostsByUser :many
+SELECT * FROM post_stars
+WHERE user_id = sqlc.arg('user_id')
+AND (
+    sqlc.narg('cursor_created_at')::timestamp IS NULL
+    OR (created_at, id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+)
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('limit');
@@ -1,15 +1,135 @@
--- name: CreatePost :one
-INSERT INTO posts (id, created_at, updated_at, title, description, published_at, url, feed_id)
-VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-RETURNING *;
+-- name: AdoptPostGuids :exec
+-- Posts stored before guids were tracked use their url as guid. Once their item is
+-- seen again they take its real guid, unless another post in the feed already has it
+UPDATE posts
+SET guid = items.guid
+FROM unnest(sqlc.arg('urls')::text[], sqlc.arg('guids')::text[]) AS items(url, guid)
+WHERE posts.feed_id = sqlc.arg('feed_id')::uuid
+AND posts.guid = posts.url
+AND posts.url = items.url
+AND items.guid <> items.url
+AND NOT EXISTS (
+    SELECT 1 FROM posts existing
+    WHERE existing.feed_id = posts.feed_id
+    AND existing.guid = items.guid
+);
+
+-- name: UpsertPosts :many
+-- Inserts new posts and updates existing ones whose content hash changed, saving
+-- their previous version in post_revisions. Posts are matched by guid within the
+-- feed. Posts stored before hashing have no hash yet; they are updated to
+-- backfill it but get no revision
+WITH items AS (
+    SELECT * FROM unnest(
+        sqlc.arg('ids')::uuid[],
+        sqlc.arg('titles')::text[],
+        sqlc.arg('descriptions')::text[],
+        sqlc.arg('published_ats')::timestamp[],
+        sqlc.arg('urls')::text[],
+        sqlc.arg('guids')::text[],
+        sqlc.arg('content_hashes')::text[]
+    ) AS items(id, title, description, published_at, url, guid, content_hash)
+),
+previous AS (
+    SELECT posts.id, posts.title, posts.description, posts.published_at, posts.content_hash
+    FROM posts
+    JOIN items ON items.guid = posts.guid
+    WHERE posts.feed_id = sqlc.arg('feed_id')::uuid
+    AND posts.content_hash IS DISTINCT FROM items.content_hash
+    FOR UPDATE OF posts
+),
+upserted AS (
+    INSERT INTO posts (id, created_at, updated_at, title, description, published_at, url, feed_id, guid, content_hash)
+    SELECT items.id, sqlc.arg('created_at')::timestamp, sqlc.arg('created_at')::timestamp, items.title, NULLIF(items.description, ''), items.published_at, items.url, sqlc.arg('feed_id')::uuid, items.guid, items.content_hash
+    FROM items
+    ON CONFLICT (feed_id, guid) DO UPDATE
+    SET title = EXCLUDED.title,
+    description = EXCLUDED.description,
+    published_at = EXCLUDED.published_at,
+    content_hash = EXCLUDED.content_hash,
+    updated_at = EXCLUDED.updated_at
+    WHERE posts.content_hash IS DISTINCT FROM EXCLUDED.content_hash
+    RETURNING posts.id, (xmax = 0) AS inserted
+),
+revisions AS (
+    INSERT INTO post_revisions (id, post_id, created_at, title, description, published_at)
+    SELECT gen_random_uuid(), previous.id, sqlc.arg('created_at')::timestamp, previous.title, previous.description, previous.published_at
+    FROM previous
+    JOIN upserted ON upserted.id = previous.id
+    WHERE previous.content_hash IS NOT NULL
+)
+SELECT upserted.id, upserted.inserted, (previous.content_hash IS NOT NULL)::boolean AS revised
+FROM upserted
+LEFT JOIN previous ON previous.id = upserted.id;
 
 
 -- name: GetPostsByUser :many
-SELECT * FROM posts
-WHERE feed_id IN (
-    SELECT id FROM feeds
-    WHERE user_id = $1
-) ORDER BY published_at DESC
-LIMIT $2;
+SELECT posts.id, posts.created_at, posts.updated_at, posts.title, posts.description, posts.published_at, posts.url, posts.feed_id FROM posts
+JOIN feed_follows ON feed_follows.feed_id = posts.feed_id
+WHERE feed_follows.user_id = sqlc.arg('user_id')
+AND (sqlc.narg('feed_ids')::uuid[] IS NULL OR posts.feed_id = ANY(sqlc.narg('feed_ids')::uuid[]))
+AND (sqlc.narg('since')::timestamp IS NULL OR posts.published_at >= sqlc.narg('since')::timestamp)
+AND (sqlc.narg('until')::timestamp IS NULL OR posts.published_at < sqlc.narg('until')::timestamp)
+AND (
+    NOT sqlc.arg('unread_only')::boolean
+    OR NOT EXISTS (SELECT 1 FROM post_reads WHERE post_reads.user_id = feed_follows.user_id AND post_reads.post_id = posts.id)
+)
+AND (
+    sqlc.narg('cursor_published_at')::timestamp IS NULL
+    OR (posts.published_at, posts.id) < (sqlc.narg('cursor_published_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+)
+ORDER BY posts.published_at DESC, posts.id DESC
+LIMIT sqlc.arg('limit');
+
+
+-- name: GetPostsByUserOrderedByIngestion :many
+SELECT posts.id, posts.created_at, posts.updated_at, posts.title, posts.description, posts.published_at, posts.url, posts.feed_id FROM posts
+JOIN feed_follows ON feed_follows.feed_id = posts.feed_id
+WHERE feed_follows.user_id = sqlc.arg('user_id')
+AND (sqlc.narg('feed_ids')::uuid[] IS NULL OR posts.feed_id = ANY(sqlc.narg('feed_ids')::uuid[]))
+AND (sqlc.narg('since')::timestamp IS NULL OR posts.created_at >= sqlc.narg('since')::timestamp)
+AND (sqlc.narg('until')::timestamp IS NULL OR posts.created_at < sqlc.narg('until')::timestamp)
+AND (
+    NOT sqlc.arg('unread_only')::boolean
+    OR NOT EXISTS (SELECT 1 FROM post_reads WHERE post_reads.user_id = feed_follows.user_id AND post_reads.post_id = posts.id)
+)
+AND (
+    sqlc.narg('cursor_created_at')::timestamp IS NULL
+    OR (posts.created_at, posts.id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+)
+ORDER BY posts.created_at DESC, posts.id DESC
+LIMIT sqlc.arg('limit');
+
+
+-- name: CountPostsByUser :one
+SELECT COUNT(*) FROM posts
+JOIN feed_follows ON feed_follows.feed_id = posts.feed_id
+WHERE feed_follows.user_id = sqlc.arg('user_id')
+AND (sqlc.narg('feed_ids')::uuid[] IS NULL OR posts.feed_id = ANY(sqlc.narg('feed_ids')::uuid[]))
+AND (
+    sqlc.narg('since')::timestamp IS NULL
+    OR CASE WHEN sqlc.arg('by_ingestion')::boolean THEN posts.created_at ELSE posts.published_at END >= sqlc.narg('since')::timestamp
+)
+AND (
+    sqlc.narg('until')::timestamp IS NULL
+    OR CASE WHEN sqlc.arg('by_ingestion')::boolean THEN posts.created_at ELSE posts.published_at END < sqlc.narg('until')::timestamp
+)
+AND (
+    NOT sqlc.arg('unread_only')::boolean
+    OR NOT EXISTS (SELECT 1 FROM post_reads WHERE post_reads.user_id = feed_follows.user_id AND post_reads.post_id = posts.id)
+);
 
 
+-- name: SearchPostsByUser :many
+SELECT posts.id, posts.created_at, posts.updated_at, posts.title, posts.description, posts.published_at, posts.url, posts.feed_id,
+    ts_rank_cd(posts.search_vector, query)::real AS rank,
+    ts_headline('english', posts.title, query, 'HighlightAll=true, StartSel=<mark>, StopSel=</mark>') AS title_highlight,
+    ts_headline('english', coalesce(posts.description, ''), query, 'MaxFragments=2, MaxWords=30, MinWords=10, StartSel=<mark>, StopSel=</mark>') AS snippet
+FROM posts
+JOIN feed_follows ON feed_follows.feed_id = posts.feed_id
+CROSS JOIN to_tsquery('english', sqlc.arg('query')) AS query
+WHERE feed_follows.user_id = sqlc.arg('user_id')
+AND posts.search_vector @@ query
+ORDER BY rank DESC, posts.published_at DESC, posts.id DESC
+LIMIT sqlc.arg('limit')
+OFFSET sqlc.arg('offset');
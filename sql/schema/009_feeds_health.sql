@@ -0,0 +1,9 @@
+-- +goose Up
+ALTER TABLE feeds ADD COLUMN last_success_at TIMESTAMP;
+ALTER TABLE feeds ADD COLUMN last_http_status INTEGER;
+ALTER TABLE feeds ADD COLUMN item_count INTEGER NOT NULL DEFAULT 0;
+
+-- +goose Down
+ALTER TABLE feeds DROP COLUMN item_count;
+ALTER TABLE feeds DROP COLUMN last_http_status;
+ALTER TABLE feeds DROP COLUMN last_success_at;
@@ -0,0 +1,7 @@
+-- +goose Up
+CREATE INDEX posts_feed_id_published_at_idx ON posts (feed_id, published_at DESC, id DESC);
+CREATE INDEX posts_feed_id_created_at_idx ON posts (feed_id, created_at DESC, id DESC);
+
+-- +goose Down
+DROP INDEX posts_feed_id_created_at_idx;
+DROP INDEX posts_feed_id_published_at_idx;
@@ -0,0 +1,19 @@
+-- +goose Up
+-- Starred posts keep a copy of the post so they survive the post or its feed being deleted
+CREATE TABLE post_stars (
+    id uuid PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    post_id uuid REFERENCES posts(id) ON DELETE SET NULL,
+    feed_id uuid REFERENCES feeds(id) ON DELETE SET NULL,
+    title TEXT NOT NULL,
+    description TEXT,
+    published_at TIMESTAMP NOT NULL,
+    url TEXT NOT NULL,
+    UNIQUE (user_id, post_id)
+);
+
+CREATE INDEX post_stars_user_id_created_at_idx ON post_stars (user_id, created_at DESC, id DESC);
+
+-- +goose Down
+DROP TABLE post_stars;
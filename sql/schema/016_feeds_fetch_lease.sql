@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE feeds ADD COLUMN leased_until TIMESTAMP;
+
+-- +goose Down
+ALTER TABLE feeds DROP COLUMN leased_until;
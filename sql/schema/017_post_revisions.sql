@@ -0,0 +1,20 @@
+-- +goose Up
+-- Hash of the item as published, used to detect changes on later fetches.
+-- NULL for posts stored before hashing, which are backfilled on their next fetch
+ALTER TABLE posts ADD COLUMN content_hash TEXT;
+
+-- Previous versions of posts that were changed by their publisher
+CREATE TABLE post_revisions (
+    id UUID PRIMARY KEY,
+    post_id UUID NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL,
+    title TEXT NOT NULL,
+    description TEXT,
+    published_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX post_revisions_post_id_created_at_idx ON post_revisions (post_id, created_at DESC);
+
+-- +goose Down
+DROP TABLE post_revisions;
+ALTER TABLE posts DROP COLUMN content_hash;
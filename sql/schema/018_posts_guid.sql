@@ -0,0 +1,21 @@
+-- +goose Up
+-- Posts are identified within their feed by the item's guid rather than by a
+-- globally unique url, so feeds linking to the same article each get a post.
+-- Existing posts use their url as guid until the scraper sees their item again
+ALTER TABLE posts ADD COLUMN guid TEXT;
+UPDATE posts SET guid = url;
+ALTER TABLE posts ALTER COLUMN guid SET NOT NULL;
+ALTER TABLE posts DROP CONSTRAINT posts_url_key;
+ALTER TABLE posts ADD CONSTRAINT posts_feed_id_guid_key UNIQUE (feed_id, guid);
+
+-- +goose Down
+-- Only the oldest post for each url survives
+DELETE FROM posts WHERE id IN (
+    SELECT id FROM (
+        SELECT id, row_number() OVER (PARTITION BY url ORDER BY created_at, id) AS n FROM posts
+    ) ranked
+    WHERE n > 1
+);
+ALTER TABLE posts DROP CONSTRAINT posts_feed_id_guid_key;
+ALTER TABLE posts ADD CONSTRAINT posts_url_key UNIQUE (url);
+ALTER TABLE posts DROP COLUMN guid;
@@ -0,0 +1,6 @@
+-- +goose Up
+-- Number of items in the last fetched document whose date couldn't be parsed
+ALTER TABLE feeds ADD COLUMN unparseable_dates INTEGER NOT NULL DEFAULT 0;
+
+-- +goose Down
+ALTER TABLE feeds DROP COLUMN unparseable_dates;
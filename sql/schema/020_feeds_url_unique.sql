@@ -0,0 +1,106 @@
+-- +goose Up
+-- Feeds added before URLs were normalized may be spelled differently or stored
+-- twice. Each URL is normalized like normalizeFeedURL does and every group of
+-- duplicates is merged into its oldest feed before feeds.url becomes unique.
+--
+-- SQL can't reproduce url.URL.String() in general, so only URLs it provably
+-- handles the same way are rewritten: http or https, a host of ASCII letters,
+-- digits, dots and dashes with an optional port, no userinfo, a path of
+-- characters Go leaves as written and valid percent escapes, a query without
+-- spaces or control characters, and a fragment without escapes. Every other
+-- URL, such as one with userinfo, an IPv6 or non-ASCII host or a path Go would
+-- escape, is kept exactly as stored and only merged with identical URLs. Such a
+-- feed is found again only when its URL normalizes to the stored text, so
+-- submitting it later may add a second feed under the normalized URL
+CREATE TEMPORARY TABLE feed_merges ON COMMIT DROP AS
+WITH normalized AS (
+    SELECT feeds.id, feeds.created_at,
+    CASE WHEN btrim(feeds.url) ~* '^https?://[a-z0-9.-]+(:[0-9]+)?(/([-a-z0-9._~!$&''()*+,;=:@/]|%[0-9a-f]{2})*)?(\?[!-"$-~]*)?(#[!-$&-~]*)?$' THEN
+        lower(parts[1]) || '://'
+        || regexp_replace(lower(parts[2]), CASE lower(parts[1]) WHEN 'http' THEN ':80$' ELSE ':443$' END, '')
+        || CASE WHEN parts[3] = '' OR parts[3] LIKE '?%' THEN '/' || parts[3] ELSE parts[3] END
+    ELSE feeds.url
+    END AS url
+    FROM feeds
+    LEFT JOIN LATERAL regexp_match(btrim(feeds.url), '^([A-Za-z][A-Za-z0-9+.-]*)://([^/?#]*)([^#]*)') AS matched(parts) ON true
+)
+SELECT id, url, first_value(id) OVER (PARTITION BY url ORDER BY created_at, id) AS keep_id
+FROM normalized;
+
+-- Posts the kept feed already has, matched by guid, so read state and stars can follow them
+CREATE TEMPORARY TABLE post_merges ON COMMIT DROP AS
+SELECT duplicate.id, kept.id AS keep_id
+FROM feed_merges
+JOIN posts duplicate ON duplicate.feed_id = feed_merges.id
+JOIN posts kept ON kept.feed_id = feed_merges.keep_id AND kept.guid = duplicate.guid
+WHERE feed_merges.id <> feed_merges.keep_id;
+
+INSERT INTO post_reads (user_id, post_id, read_at)
+SELECT post_reads.user_id, post_merges.keep_id, post_reads.read_at
+FROM post_reads
+JOIN post_merges ON post_merges.id = post_reads.post_id
+ON CONFLICT DO NOTHING;
+
+UPDATE post_stars
+SET post_id = post_merges.keep_id
+FROM post_merges
+WHERE post_stars.post_id = post_merges.id
+AND NOT EXISTS (
+    SELECT 1 FROM post_stars existing
+    WHERE existing.user_id = post_stars.user_id
+    AND existing.post_id = post_merges.keep_id
+);
+
+-- The remaining posts move to the kept feed, one per guid
+UPDATE posts
+SET feed_id = moved.keep_id
+FROM (
+    SELECT DISTINCT ON (feed_merges.keep_id, posts.guid) posts.id, feed_merges.keep_id
+    FROM posts
+    JOIN feed_merges ON feed_merges.id = posts.feed_id
+    WHERE feed_merges.id <> feed_merges.keep_id
+    AND posts.id NOT IN (SELECT id FROM post_merges)
+    ORDER BY feed_merges.keep_id, posts.guid, posts.created_at, posts.id
+) moved
+WHERE posts.id = moved.id;
+
+-- Follows move to the kept feed unless the user already follows it
+UPDATE feed_follows
+SET feed_id = moved.keep_id
+FROM (
+    SELECT DISTINCT ON (feed_follows.user_id, feed_merges.keep_id) feed_follows.id, feed_merges.keep_id
+    FROM feed_follows
+    JOIN feed_merges ON feed_merges.id = feed_follows.feed_id
+    WHERE feed_merges.id <> feed_merges.keep_id
+    AND NOT EXISTS (
+        SELECT 1 FROM feed_follows existing
+        WHERE existing.user_id = feed_follows.user_id
+        AND existing.feed_id = feed_merges.keep_id
+    )
+    ORDER BY feed_follows.user_id, feed_merges.keep_id, feed_follows.created_at, feed_follows.id
+) moved
+WHERE feed_follows.id = moved.id;
+
+UPDATE post_stars
+SET feed_id = feed_merges.keep_id
+FROM feed_merges
+WHERE post_stars.feed_id = feed_merges.id
+AND feed_merges.id <> feed_merges.keep_id;
+
+-- Whatever is left on the duplicates is deleted with them
+DELETE FROM feeds
+USING feed_merges
+WHERE feeds.id = feed_merges.id
+AND feed_merges.id <> feed_merges.keep_id;
+
+UPDATE feeds
+SET url = feed_merges.url
+FROM feed_merges
+WHERE feeds.id = feed_merges.id
+AND feeds.url <> feed_merges.url;
+
+ALTER TABLE feeds ADD CONSTRAINT feeds_url_key UNIQUE (url);
+
+-- +goose Down
+-- Merged feeds are not split again
+ALTER TABLE feeds DROP CONSTRAINT feeds_url_key;
@@ -0,0 +1,8 @@
+-- +goose Up
+-- Content hashes no longer cover dates that aren't stored on the post. Clearing
+-- them lets the next fetch backfill the new hashes without writing revisions
+-- for posts that didn't change
+UPDATE posts SET content_hash = NULL WHERE content_hash IS NOT NULL;
+
+-- +goose Down
+-- The old hashes can't be recomputed here; they are backfilled on the next fetch